@@ -66,11 +66,37 @@ func NewJobAPI(node *JobNode, auth *APIAuth) (api *JobAPI) {
 	e.Post("/group/list", api.groupList, jwtAuth)
 	e.Post("/node/list", api.nodeList, jwtAuth)
 	e.Post("/plan/list", api.planList, jwtAuth)
+	e.Post("/queue/stats", api.queueStats, jwtAuth)
+	e.Post("/cron/preview", api.cronPreview, jwtAuth)
+	e.Post("/params/preview", api.paramsPreview, jwtAuth)
 	e.Post("/client/list", api.clientList, jwtAuth)
+	e.Post("/client/cordon", api.cordonClient, jwtAuth)
+	e.Post("/client/uncordon", api.uncordonClient, jwtAuth)
+	e.Post("/client/drain", api.drainClient, jwtAuth)
+	e.Post("/client/cordon/list", api.cordonList, jwtAuth)
 	e.Post("/snapshot/list", api.snapshotList, jwtAuth)
 	e.Post("/snapshot/delete", api.snapshotDelete, jwtAuth)
+	e.Post("/snapshot/waiting/list", api.waitingSnapshotList, jwtAuth)
+	e.Post("/snapshot/pending/list", api.pendingSnapshotList, jwtAuth)
 	e.Post("/execute/snapshot/list", api.executeSnapshotList, jwtAuth)
 	e.Post("/execute/snapshot/retry/:id", api.executeSnapshotRetry, jwtAuth)
+	e.Post("/workflow/add", api.addWorkflow, jwtAuth)
+	e.Post("/workflow/edit", api.editWorkflow, jwtAuth)
+	e.Post("/workflow/delete", api.deleteWorkflow, jwtAuth)
+	e.Post("/workflow/list", api.workflowList, jwtAuth)
+	e.Post("/workflow/run", api.runWorkflow, jwtAuth)
+	e.Post("/workflow/instance/list", api.workflowInstanceList, jwtAuth)
+	e.Post("/workflow/instance/cancel", api.cancelWorkflowInstance, jwtAuth)
+	e.Post("/calendar/add", api.addCalendar, jwtAuth)
+	e.Post("/calendar/edit", api.editCalendar, jwtAuth)
+	e.Post("/calendar/delete", api.deleteCalendar, jwtAuth)
+	e.Post("/calendar/list", api.calendarList, jwtAuth)
+	e.Post("/delayed/add", api.addDelayedTask, jwtAuth)
+	e.Post("/delayed/list", api.delayedTaskList, jwtAuth)
+	e.Post("/delayed/cancel", api.cancelDelayedTask, jwtAuth)
+	e.Post("/backfill/add", api.addBackfill, jwtAuth)
+	e.Post("/backfill/list", api.backfillList, jwtAuth)
+	e.Post("/backfill/cancel", api.cancelBackfill, jwtAuth)
 
 	// 外部服务接口
 	service := e.Group("/service", APIServiceAuth(func() interface{} {
@@ -79,6 +105,12 @@ func NewJobAPI(node *JobNode, auth *APIAuth) (api *JobAPI) {
 	// /service/snapshot/add
 	service.Post("/snapshot/add", api.snapshotAdd) // 添加一次性临时任务
 
+	delayedService := e.Group("/service/delayed", APIServiceAuth(func() interface{} {
+		return new(DelayedTask)
+	}))
+	// /service/delayed/add
+	delayedService.Post("/add", api.delayedTaskAdd) // 添加定时执行一次的延迟任务
+
 	return
 }
 
@@ -164,14 +196,16 @@ func (api *JobAPI) addJob(context echo.Context) (err error) {
 		goto ERROR
 	}
 
-	if len(jobConf.Cron) == 0 {
-		message = "任务Cron表达式不能为空"
-		goto ERROR
-	}
+	if len(jobConf.ScheduleType) == 0 || jobConf.ScheduleType == ScheduleCron {
+		if len(jobConf.Cron) == 0 {
+			message = "任务Cron表达式不能为空"
+			goto ERROR
+		}
 
-	if _, err = cron.Parse(jobConf.Cron); err != nil {
-		message = "非法的Cron表达式"
-		goto ERROR
+		if _, err = ParseCron(jobConf.Cron); err != nil {
+			message = "非法的Cron表达式: " + err.Error()
+			goto ERROR
+		}
 	}
 
 	if len(jobConf.Target) == 0 {
@@ -217,14 +251,12 @@ func (api *JobAPI) editJob(context echo.Context) (err error) {
 		goto ERROR
 	}
 
-	if len(jobConf.Cron) == 0 {
-		message = "任务Cron表达式不能为空"
-		goto ERROR
-	}
-
-	if _, err = cron.Parse(jobConf.Cron); err != nil {
-		message = "非法的Cron表达式"
-		goto ERROR
+	if len(jobConf.ScheduleType) == 0 || jobConf.ScheduleType == ScheduleCron {
+		if len(jobConf.Cron) == 0 {
+			message = "任务Cron表达式不能为空"
+			goto ERROR
+		}
+		// the cron is checked by the semantics the job saved with
 	}
 
 	if len(jobConf.Target) == 0 {
@@ -428,6 +460,119 @@ func (api *JobAPI) planList(context echo.Context) (err error) {
 	return context.JSON(Result{Code: CodeSuccess, Data: plans})
 }
 
+// preview the next fire times of the cron expression
+func (api *JobAPI) cronPreview(context echo.Context) (err error) {
+	var (
+		message  string
+		schedule cron.Schedule
+		location *time.Location
+		times    []string
+		next     time.Time
+	)
+	query := new(QueryCronPreviewParam)
+	if err = context.MustBind(query); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if schedule, err = ParseCron(query.Cron); err != nil {
+		message = "非法的Cron表达式: " + err.Error()
+		goto ERROR
+	}
+	if location, err = LoadLocation(query.Timezone); err != nil {
+		message = "非法的时区: " + query.Timezone
+		goto ERROR
+	}
+	if query.Count <= 0 {
+		query.Count = 5
+	}
+	if query.Count > 100 {
+		query.Count = 100
+	}
+	next = time.Now().In(location)
+	for i := 0; i < query.Count; i++ {
+		if next = schedule.Next(next); next.IsZero() {
+			break
+		}
+		times = append(times, next.Format("2006-01-02 15:04:05 -0700"))
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: times, Message: "查询成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// the snapshots waiting for the concurrency limit
+func (api *JobAPI) waitingSnapshotList(context echo.Context) (err error) {
+	var snapshots []*JobSnapshot
+	query := new(QueryClientParam)
+	if err = context.MustBind(query); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: "解析请求参数失败: " + err.Error()})
+	}
+	if snapshots, err = api.node.manager.WaitingSnapshotList(query.Group); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: snapshots, Message: "查询成功"})
+}
+
+// the snapshots waiting for the clients of the group
+func (api *JobAPI) pendingSnapshotList(context echo.Context) (err error) {
+	var snapshots []*JobSnapshot
+	query := new(QueryClientParam)
+	if err = context.MustBind(query); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: "解析请求参数失败: " + err.Error()})
+	}
+	if snapshots, err = api.node.manager.PendingSnapshotList(query.Group); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: snapshots, Message: "查询成功"})
+}
+
+// the stats of the dispatch queue on the node
+func (api *JobAPI) queueStats(context echo.Context) (err error) {
+	return context.JSON(Result{Code: CodeSuccess, Data: api.node.exec.queueStats(), Message: "查询成功"})
+}
+
+// preview the params template rendered with the variables
+func (api *JobAPI) paramsPreview(context echo.Context) (err error) {
+	var (
+		message       string
+		location      *time.Location
+		scheduledTime time.Time
+		params        string
+	)
+	query := new(QueryParamsPreviewParam)
+	if err = context.MustBind(query); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if location, err = LoadLocation(query.Timezone); err != nil {
+		message = "非法的时区: " + query.Timezone
+		goto ERROR
+	}
+	scheduledTime = time.Now().In(location)
+	if len(query.ScheduledTime) > 0 {
+		if scheduledTime, err = time.ParseInLocation("2006-01-02 15:04:05", query.ScheduledTime, location); err != nil {
+			message = "非法的执行时间: " + query.ScheduledTime
+			goto ERROR
+		}
+	}
+	params, err = renderParams(query.Params, &ParamsTemplateData{
+		ScheduledTime: scheduledTime,
+		SnapshotId:    GenerateSerialNo(),
+		Attempt:       query.Attempt,
+		Group:         query.Group,
+		Vars:          api.node.manager.paramsVars(query.Group, query.Vars),
+	})
+	if err != nil {
+		message = "非法的参数模板: " + err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: params, Message: "查询成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
 func (api *JobAPI) clientList(context echo.Context) (err error) {
 
 	var (
@@ -456,11 +601,20 @@ func (api *JobAPI) clientList(context echo.Context) (err error) {
 		goto ERROR
 	}
 
+	group.lk.RLock()
 	clients = make([]*JobClient, len(group.clients))
 	for _, c := range group.clients {
-		clients[i] = &JobClient{Name: c.name, Path: c.path, Group: query.Group}
+		clients[i] = &JobClient{Name: c.name, Path: c.path, Group: query.Group, Weight: c.weight(), Labels: c.labels()}
+		if c.meta != nil {
+			clients[i].Version = c.meta.Version
+			clients[i].Capacity = c.meta.Capacity
+			clients[i].Running = c.meta.Running
+		}
+		clients[i].Used = group.usedSlots(c)
+		clients[i].Cordoned = group.cordoned[c.name]
 		i++
 	}
+	group.lk.RUnlock()
 
 	return context.JSON(Result{Code: CodeSuccess, Data: clients, Message: "查询成功"})
 
@@ -468,6 +622,73 @@ ERROR:
 	return context.JSON(Result{Code: CodeFailure, Message: message})
 }
 
+// stop selecting the client for the new snapshots
+func (api *JobAPI) cordonClient(context echo.Context) (err error) {
+	var message string
+	cordon := new(Cordon)
+	if err = context.MustBind(cordon); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.CordonClient(cordon); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: cordon, Message: "停止调度成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// select the client for the new snapshots again
+func (api *JobAPI) uncordonClient(context echo.Context) (err error) {
+	var message string
+	cordon := new(Cordon)
+	if err = context.MustBind(cordon); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.UncordonClient(cordon); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: cordon, Message: "恢复调度成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// cordon the client and move the snapshots not started yet to the other clients
+func (api *JobAPI) drainClient(context echo.Context) (err error) {
+	var message string
+	cordon := new(Cordon)
+	if err = context.MustBind(cordon); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.DrainClient(cordon); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: cordon, Message: "已开始排空"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// the cordoned clients
+func (api *JobAPI) cordonList(context echo.Context) (err error) {
+	var cordons []*Cordon
+	query := new(QueryClientParam)
+	if err = context.MustBind(query); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: "解析请求参数失败: " + err.Error()})
+	}
+	if cordons, err = api.node.manager.CordonList(query.Group); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: cordons, Message: "查询成功"})
+}
+
 // 任务快照
 func (api *JobAPI) snapshotList(context echo.Context) (err error) {
 
@@ -748,3 +969,377 @@ func (api *JobAPI) snapshotAdd(context echo.Context) (err error) {
 	}
 	return context.JSON(Result{Code: CodeSuccess, Message: "临时任务已提交"})
 }
+
+// add a new workflow
+func (api *JobAPI) addWorkflow(context echo.Context) (err error) {
+	var message string
+	conf := new(WorkflowConf)
+	if err = context.MustBind(conf); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.AddWorkflow(conf); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: conf, Message: "创建成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// edit a workflow
+func (api *JobAPI) editWorkflow(context echo.Context) (err error) {
+	var message string
+	conf := new(WorkflowConf)
+	if err = context.MustBind(conf); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if len(conf.Id) == 0 {
+		message = "此工作流不存在"
+		goto ERROR
+	}
+	if err = api.node.manager.EditWorkflow(conf); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: conf, Message: "修改成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// delete a workflow
+func (api *JobAPI) deleteWorkflow(context echo.Context) (err error) {
+	var message string
+	conf := new(WorkflowConf)
+	if err = context.MustBind(conf); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.DeleteWorkflow(conf); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: conf, Message: "删除成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// workflow list
+func (api *JobAPI) workflowList(context echo.Context) (err error) {
+	var confs []*WorkflowConf
+	if confs, err = api.node.manager.WorkflowList(); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: confs, Message: "查询成功"})
+}
+
+// run a workflow
+func (api *JobAPI) runWorkflow(context echo.Context) (err error) {
+	var (
+		message  string
+		instance *WorkflowInstance
+	)
+	conf := new(WorkflowConf)
+	if err = context.MustBind(conf); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if len(conf.Id) == 0 {
+		message = "此工作流不存在"
+		goto ERROR
+	}
+	if instance, err = api.node.workflow.Run(conf.Id); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: instance, Message: "工作流已提交"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+type WorkflowInstanceExt struct {
+	*WorkflowInstance
+	Nodes []*WorkflowInstanceNode `json:"nodes" db:"-"`
+}
+
+// workflow instance list
+func (api *JobAPI) workflowInstanceList(context echo.Context) (err error) {
+
+	var (
+		query     *QueryWorkflowInstanceParam
+		message   string
+		count     uint64
+		instances []*WorkflowInstanceExt
+		totalPage uint64
+		where     = db.NewCompounds()
+	)
+
+	query = new(QueryWorkflowInstanceParam)
+	if err = context.MustBind(query); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+
+	if query.PageSize <= 0 {
+		query.PageSize = 10
+	}
+
+	if query.PageNo <= 0 {
+		query.PageNo = 1
+	}
+
+	instances = []*WorkflowInstanceExt{}
+	if len(query.Id) > 0 {
+		where.AddKV(`id`, query.Id)
+	}
+	if len(query.WorkflowId) > 0 {
+		where.AddKV(`workflow_id`, query.WorkflowId)
+	}
+	if query.Status != 0 {
+		where.AddKV(`status`, query.Status)
+	}
+	if count, err = api.node.UseTable(TableWorkflowInstance).
+		Find(where.And()).
+		Count(); err != nil {
+		log.Errorf("err: %#v", err)
+		message = "查询失败"
+		goto ERROR
+	}
+
+	if count > 0 {
+		err = api.node.UseTable(TableWorkflowInstance).
+			Find(where.And()).
+			OrderBy(`-create_time`).
+			Limit(query.PageSize).
+			Offset((query.PageNo - 1) * query.PageSize).
+			All(&instances)
+		if err != nil {
+			log.Errorf("err: %#v", err)
+			message = "查询失败"
+			goto ERROR
+		}
+
+		if count%uint64(query.PageSize) == 0 {
+			totalPage = count / uint64(query.PageSize)
+		} else {
+			totalPage = count/uint64(query.PageSize) + 1
+		}
+
+		for _, instance := range instances {
+			if instance.WorkflowInstance == nil {
+				continue
+			}
+			if instance.Nodes, err = api.node.workflow.loadNodes(instance.Id); err != nil {
+				log.Errorf("err: %#v", err)
+			}
+		}
+	}
+
+	return context.JSON(Result{
+		Code: CodeSuccess,
+		Data: &PageResult{
+			TotalCount: int(count),
+			TotalPage:  int(totalPage),
+			List:       &instances,
+		},
+		Message: "查询成功",
+	})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// cancel a workflow instance
+func (api *JobAPI) cancelWorkflowInstance(context echo.Context) (err error) {
+	var message string
+	query := new(QueryWorkflowInstanceParam)
+	if err = context.MustBind(query); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if len(query.Id) == 0 {
+		message = "此工作流实例不存在"
+		goto ERROR
+	}
+	if err = api.node.workflow.Cancel(query.Id); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Message: "工作流实例已取消"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// add a delayed task
+func (api *JobAPI) addDelayedTask(context echo.Context) (err error) {
+	var message string
+	task := new(DelayedTask)
+	if err = context.MustBind(task); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.AddDelayedTask(task); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: task, Message: "创建成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// delayed task list
+func (api *JobAPI) delayedTaskList(context echo.Context) (err error) {
+	var tasks []*DelayedTask
+	if tasks, err = api.node.manager.DelayedTaskList(); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: tasks, Message: "查询成功"})
+}
+
+// cancel a delayed task
+func (api *JobAPI) cancelDelayedTask(context echo.Context) (err error) {
+	var message string
+	task := new(DelayedTask)
+	if err = context.MustBind(task); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.CancelDelayedTask(task.Id); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: task, Message: "取消成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+func (api *JobAPI) delayedTaskAdd(context echo.Context) (err error) {
+	task, ok := context.Internal().Get(`recv`).(*DelayedTask)
+	if !ok || task == nil {
+		return context.JSON(Result{Code: CodeFailure, Message: "非法的参数"})
+	}
+	if err = api.node.manager.AddDelayedTask(task); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: task, Message: "延迟任务已提交"})
+}
+
+// add a backfill
+func (api *JobAPI) addBackfill(context echo.Context) (err error) {
+	var message string
+	backfill := new(Backfill)
+	if err = context.MustBind(backfill); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.AddBackfill(backfill); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: backfill, Message: "创建成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// backfill list
+func (api *JobAPI) backfillList(context echo.Context) (err error) {
+	var backfills []*Backfill
+	if backfills, err = api.node.manager.BackfillList(); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: backfills, Message: "查询成功"})
+}
+
+// cancel a backfill
+func (api *JobAPI) cancelBackfill(context echo.Context) (err error) {
+	var message string
+	backfill := new(Backfill)
+	if err = context.MustBind(backfill); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.CancelBackfill(backfill.Id); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: backfill, Message: "取消成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// add a calendar
+func (api *JobAPI) addCalendar(context echo.Context) (err error) {
+	var message string
+	calendar := new(Calendar)
+	if err = context.MustBind(calendar); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.AddCalendar(calendar); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: calendar, Message: "添加成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// edit a calendar
+func (api *JobAPI) editCalendar(context echo.Context) (err error) {
+	var message string
+	calendar := new(Calendar)
+	if err = context.MustBind(calendar); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if len(calendar.Name) == 0 {
+		message = "日历名称不能为空"
+		goto ERROR
+	}
+	if err = api.node.manager.EditCalendar(calendar); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: calendar, Message: "修改成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// delete a calendar
+func (api *JobAPI) deleteCalendar(context echo.Context) (err error) {
+	var message string
+	calendar := new(Calendar)
+	if err = context.MustBind(calendar); err != nil {
+		message = "解析请求参数失败: " + err.Error()
+		goto ERROR
+	}
+	if err = api.node.manager.DeleteCalendar(calendar); err != nil {
+		message = err.Error()
+		goto ERROR
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: calendar, Message: "删除成功"})
+
+ERROR:
+	return context.JSON(Result{Code: CodeFailure, Message: message})
+}
+
+// calendar list
+func (api *JobAPI) calendarList(context echo.Context) (err error) {
+	var calendars []*Calendar
+	if calendars, err = api.node.manager.CalendarList(); err != nil {
+		return context.JSON(Result{Code: CodeFailure, Message: err.Error()})
+	}
+	return context.JSON(Result{Code: CodeSuccess, Data: calendars, Message: "查询成功"})
+}
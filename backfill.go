@@ -0,0 +1,376 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/andistributed/etcd/etcdevent"
+	"github.com/robfig/cron"
+)
+
+// the backfill run the job for each schedule time of a historical time range, the leader
+// dispatch the logical times one by one, each after the previous one finished, and record
+// the progress so the new leader can resume it
+
+const (
+	BackfillPath = "/forest/server/backfill/" // + backfill.id
+)
+
+// BackfillLimit the max logical times of a backfill
+var BackfillLimit = 10000
+
+// backfillLostGrace the snapshot of the logical time not found anywhere longer than the grace is lost
+const backfillLostGrace = time.Minute
+
+// backfillRange the schedule times of the job between the start time and the end time
+type backfillRange struct {
+	schedule cron.Schedule
+	location *time.Location
+	start    time.Time
+	end      time.Time
+}
+
+func newBackfillRange(conf *JobConf, backfill *Backfill) (r *backfillRange, err error) {
+	r = &backfillRange{}
+	if r.schedule, err = newSchedule(conf); err != nil {
+		return nil, fmt.Errorf("非法的调度规则: %w", err)
+	}
+	if r.location, err = LoadLocation(conf.Timezone); err != nil {
+		return nil, fmt.Errorf("非法的时区: %s", conf.Timezone)
+	}
+	if r.start, err = time.ParseInLocation("2006-01-02 15:04:05", backfill.StartTime, r.location); err != nil {
+		return nil, fmt.Errorf("非法的开始时间: %s", backfill.StartTime)
+	}
+	if r.end, err = time.ParseInLocation("2006-01-02 15:04:05", backfill.EndTime, r.location); err != nil {
+		return nil, fmt.Errorf("非法的结束时间: %s", backfill.EndTime)
+	}
+	if r.end.Before(r.start) {
+		return nil, errors.New("开始时间不能晚于结束时间")
+	}
+	return
+}
+
+// the logical time after the last one, the zero time if no more
+func (r *backfillRange) next(last time.Time) time.Time {
+	if last.IsZero() {
+		last = r.start.Add(-time.Second)
+	}
+	next := r.schedule.Next(last.In(r.location))
+	if next.IsZero() || next.After(r.end) {
+		return time.Time{}
+	}
+	return next
+}
+
+// count the logical times, stop counting when exceed the limit
+func (r *backfillRange) count(limit int) (count int) {
+	for t := r.next(time.Time{}); !t.IsZero() && count <= limit; t = r.next(t) {
+		count++
+	}
+	return
+}
+
+// JobBackfiller dispatch the backfills on the leader
+type JobBackfiller struct {
+	node    *JobNode
+	running map[string]bool
+	missing map[string]time.Time // the first time the snapshot of the logical time not found
+	lk      *sync.Mutex
+}
+
+func NewJobBackfiller(node *JobNode) (backfiller *JobBackfiller) {
+	backfiller = &JobBackfiller{
+		node:    node,
+		running: make(map[string]bool),
+		missing: make(map[string]time.Time),
+		lk:      &sync.Mutex{},
+	}
+	go backfiller.watch()
+	return
+}
+
+func (backfiller *JobBackfiller) watch() {
+	keyChangeEventResponse := backfiller.node.etcd.WatchWithPrefixKey(BackfillPath)
+	for event := range keyChangeEventResponse.Event {
+		if event.Type != etcdevent.KeyCreateChangeEvent || backfiller.node.state != NodeLeaderState {
+			continue
+		}
+		go backfiller.run(strings.TrimPrefix(event.Key, BackfillPath))
+	}
+}
+
+// resume the backfills not finished when the node become the leader
+func (backfiller *JobBackfiller) notify(state int) {
+	if state != NodeLeaderState {
+		return
+	}
+	backfills, err := backfiller.node.manager.BackfillList()
+	if err != nil {
+		log.Errorf("load the backfills error: %v", err)
+		return
+	}
+	for _, backfill := range backfills {
+		if backfill.Status == BackfillDoingStatus {
+			go backfiller.run(backfill.Id)
+		}
+	}
+}
+
+// run the backfill until finished, canceled or the node is not the leader
+func (backfiller *JobBackfiller) run(id string) {
+	backfiller.lk.Lock()
+	if backfiller.running[id] {
+		backfiller.lk.Unlock()
+		return
+	}
+	backfiller.running[id] = true
+	backfiller.lk.Unlock()
+	defer func() {
+		backfiller.lk.Lock()
+		delete(backfiller.running, id)
+		backfiller.lk.Unlock()
+	}()
+	log.Infof("the backfill: %s start to dispatch", id)
+	for backfiller.node.state == NodeLeaderState {
+		interval, ok := backfiller.dispatch(id)
+		if !ok {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// dispatch the next logical time of the backfill, return false if the backfill finished or canceled
+func (backfiller *JobBackfiller) dispatch(id string) (interval time.Duration, ok bool) {
+	value, err := backfiller.node.etcd.Get(BackfillPath + id)
+	if err != nil {
+		log.Warnf("load the backfill: %s error: %v", id, err)
+		return time.Second, true
+	}
+	if len(value) == 0 {
+		return
+	}
+	backfill, err := UnpackBackfill(value)
+	if err != nil {
+		log.Errorf("unpack the backfill: %s error: %v", id, err)
+		return
+	}
+	if backfill.Status != BackfillDoingStatus {
+		return
+	}
+	conf, err := backfiller.node.manager.GetJob(backfill.JobId)
+	if err != nil {
+		log.Errorf("the backfill: %s load the job: %s error: %v", id, backfill.JobId, err)
+		backfiller.finish(backfill, value, BackfillCanceledStatus)
+		return
+	}
+	r, err := newBackfillRange(conf, backfill)
+	if err != nil {
+		log.Errorf("the backfill: %s error: %v", id, err)
+		backfiller.finish(backfill, value, BackfillCanceledStatus)
+		return
+	}
+	if len(backfill.SnapshotId) > 0 {
+		if !backfiller.lastFinished(backfill, conf.Group) {
+			return time.Second, true
+		}
+		backfill.Done++
+		backfill.SnapshotId = ``
+	}
+	var last time.Time
+	if len(backfill.LastTime) > 0 {
+		if last, err = time.ParseInLocation("2006-01-02 15:04:05", backfill.LastTime, r.location); err != nil {
+			log.Errorf("the backfill: %s parse the last time: %s error: %v", id, backfill.LastTime, err)
+			backfiller.finish(backfill, value, BackfillCanceledStatus)
+			return
+		}
+	}
+	logicalTime := r.next(last)
+	if logicalTime.IsZero() {
+		backfiller.finish(backfill, value, BackfillFinishedStatus)
+		return
+	}
+
+	// record the progress before dispatch, the canceled backfill fail to update
+	snapshot := backfiller.node.manager.newJobSnapshot(conf, logicalTime)
+	backfill.LastTime = ToDateString(logicalTime)
+	backfill.SnapshotId = snapshot.Id
+	if !backfiller.save(backfill, value) {
+		return time.Second, true
+	}
+	log.Infof("the backfill: %s execute the job: %s for the logical time: %s", id, conf.Id, snapshot.LogicalTime)
+	backfiller.node.exec.pushSnapshot(snapshot)
+
+	interval = time.Second
+	if backfill.Interval > 0 {
+		interval = time.Duration(backfill.Interval) * time.Second
+	}
+	return interval, true
+}
+
+// check the execution of the last dispatched logical time finished, the snapshot not found
+// anywhere longer than the grace is lost and treated as finished so the backfill never stall
+func (backfiller *JobBackfiller) lastFinished(backfill *Backfill, group string) bool {
+	id := backfill.SnapshotId
+	executeSnapshot, err := backfiller.node.collection.findExecuteSnapshot(id)
+	if err != nil {
+		log.Warnf("the backfill: %s load the snapshot: %s error: %v", backfill.Id, id, err)
+		return false
+	}
+	if executeSnapshot != nil {
+		return executeSnapshot.Status != JobExecuteSnapshotDoingStatus
+	}
+	found := backfiller.node.exec.queue.contains(id) || backfiller.node.scheduler.dispatched(&JobSnapshot{Id: id, Group: group})
+	backfiller.lk.Lock()
+	defer backfiller.lk.Unlock()
+	if found {
+		delete(backfiller.missing, id)
+		return false
+	}
+	since, ok := backfiller.missing[id]
+	if !ok {
+		backfiller.missing[id] = time.Now()
+		return false
+	}
+	if time.Since(since) < backfillLostGrace {
+		return false
+	}
+	delete(backfiller.missing, id)
+	log.Warnf("the backfill: %s lost the snapshot: %s of the logical time: %s", backfill.Id, id, backfill.LastTime)
+	return true
+}
+
+func (backfiller *JobBackfiller) finish(backfill *Backfill, old []byte, status int) {
+	backfill.Status = status
+	backfill.FinishTime = ToDateString(time.Now())
+	if backfiller.save(backfill, old) {
+		log.Infof("the backfill: %s finished, status: %d, done: %d/%d", backfill.Id, status, backfill.Done, backfill.Total)
+	}
+}
+
+// save the backfill if it not changed since loaded
+func (backfiller *JobBackfiller) save(backfill *Backfill, old []byte) bool {
+	value, err := PackBackfill(backfill)
+	if err != nil {
+		log.Errorf("pack the backfill: %s error: %v", backfill.Id, err)
+		return false
+	}
+	success, err := backfiller.node.etcd.Update(BackfillPath+backfill.Id, string(value), string(old))
+	if err != nil {
+		log.Errorf("update the backfill: %s error: %v", backfill.Id, err)
+		return false
+	}
+	return success
+}
+
+// AddBackfill add a backfill of the job for the time range
+func (manager *JobManager) AddBackfill(backfill *Backfill) (err error) {
+	var (
+		conf    *JobConf
+		r       *backfillRange
+		value   []byte
+		success bool
+	)
+	if len(backfill.JobId) == 0 {
+		err = errors.New("任务id不能为空")
+		return
+	}
+	if conf, err = manager.GetJob(backfill.JobId); err != nil {
+		return
+	}
+	if backfill.Interval < 0 {
+		err = errors.New("派发间隔不能小于0")
+		return
+	}
+	if r, err = newBackfillRange(conf, backfill); err != nil {
+		return
+	}
+	backfill.Total = r.count(BackfillLimit)
+	if backfill.Total == 0 {
+		err = errors.New("时间范围内没有需要补跑的执行时间")
+		return
+	}
+	if backfill.Total > BackfillLimit {
+		err = fmt.Errorf("补跑的执行次数不能超过%d", BackfillLimit)
+		return
+	}
+	backfill.Id = GenerateSerialNo()
+	backfill.Status = BackfillDoingStatus
+	backfill.Done = 0
+	backfill.LastTime = ``
+	backfill.SnapshotId = ``
+	backfill.CreateTime = ToDateString(time.Now())
+	backfill.FinishTime = ``
+	if value, err = PackBackfill(backfill); err != nil {
+		return
+	}
+	if success, _, err = manager.node.etcd.PutNotExist(BackfillPath+backfill.Id, string(value)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("创建失败,请重试！")
+	}
+	return
+}
+
+// CancelBackfill cancel the backfill not finished
+func (manager *JobManager) CancelBackfill(id string) (err error) {
+	var (
+		value    []byte
+		v        []byte
+		backfill *Backfill
+		success  bool
+	)
+	if len(id) == 0 {
+		err = errors.New("此补跑任务不存在")
+		return
+	}
+	if value, err = manager.node.etcd.Get(BackfillPath + id); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此补跑任务不存在")
+		return
+	}
+	if backfill, err = UnpackBackfill(value); err != nil {
+		return
+	}
+	if backfill.Status != BackfillDoingStatus {
+		err = errors.New("此补跑任务已结束")
+		return
+	}
+	backfill.Status = BackfillCanceledStatus
+	backfill.FinishTime = ToDateString(time.Now())
+	if v, err = PackBackfill(backfill); err != nil {
+		return
+	}
+	if success, err = manager.node.etcd.Update(BackfillPath+id, string(v), string(value)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("取消失败,请重试！")
+	}
+	return
+}
+
+// BackfillList backfill list
+func (manager *JobManager) BackfillList() (backfills []*Backfill, err error) {
+	var values [][]byte
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(BackfillPath); err != nil {
+		return
+	}
+	backfills = make([]*Backfill, 0, len(values))
+	for _, value := range values {
+		backfill, err := UnpackBackfill(value)
+		if err != nil {
+			log.Errorf("unpack the backfill error: %#v", err)
+			continue
+		}
+		backfills = append(backfills, backfill)
+	}
+	return
+}
@@ -0,0 +1,30 @@
+package forest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackfillRange(t *testing.T) {
+	conf := &JobConf{Cron: "0 * * * *", Timezone: "Asia/Shanghai", CronVersion: CronVersion}
+	r, err := newBackfillRange(conf, &Backfill{StartTime: "2024-01-01 00:00:00", EndTime: "2024-01-01 03:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := r.count(BackfillLimit); count != 4 {
+		t.Fatalf("the count: %d", count)
+	}
+	var times []string
+	for logicalTime := r.next(time.Time{}); !logicalTime.IsZero(); logicalTime = r.next(logicalTime) {
+		times = append(times, ToDateString(logicalTime))
+	}
+	if len(times) != 4 || times[0] != "2024-01-01 00:00:00" || times[3] != "2024-01-01 03:00:00" {
+		t.Fatalf("the logical times: %v", times)
+	}
+	if count := r.count(2); count != 3 {
+		t.Fatalf("the count not stop at the limit: %d", count)
+	}
+	if _, err = newBackfillRange(conf, &Backfill{StartTime: "2024-01-02 00:00:00", EndTime: "2024-01-01 00:00:00"}); err == nil {
+		t.Fatal("the reversed range not detected")
+	}
+}
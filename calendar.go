@@ -0,0 +1,353 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/andistributed/etcd/etcdevent"
+)
+
+const (
+	CalendarPath = "/forest/server/calendar/" // + calendar.name
+)
+
+// calendarDeferLimit the max duration to look for the time allowed by the calendar
+const calendarDeferLimit = 366 * 24 * time.Hour
+
+// calendarRule the compiled calendar
+type calendarRule struct {
+	location  *time.Location
+	holidays  map[string]bool
+	windows   []calendarWindowRule
+	blackouts [][2]time.Time
+}
+
+type calendarWindowRule struct {
+	weekdays   [7]bool
+	start, end int // the minutes of the day
+}
+
+// compile the calendar, return the error if the calendar is invalid
+func compileCalendar(calendar *Calendar) (rule *calendarRule, err error) {
+	rule = &calendarRule{holidays: make(map[string]bool, len(calendar.Holidays))}
+	if rule.location, err = LoadLocation(calendar.Timezone); err != nil {
+		return nil, fmt.Errorf("非法的时区: %s", calendar.Timezone)
+	}
+	for _, holiday := range calendar.Holidays {
+		if _, err = time.Parse("2006-01-02", holiday); err != nil {
+			return nil, fmt.Errorf("非法的节假日: %s", holiday)
+		}
+		rule.holidays[holiday] = true
+	}
+	for _, window := range calendar.Windows {
+		windowRule := calendarWindowRule{}
+		if len(window.Weekdays) == 0 {
+			windowRule.weekdays = [7]bool{true, true, true, true, true, true, true}
+		}
+		for _, weekday := range window.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return nil, fmt.Errorf("非法的星期: %d", weekday)
+			}
+			windowRule.weekdays[weekday] = true
+		}
+		if windowRule.start, err = parseClock(window.Start); err != nil {
+			return nil, fmt.Errorf("非法的时间窗口开始时间: %s", window.Start)
+		}
+		if windowRule.end, err = parseClock(window.End); err != nil {
+			return nil, fmt.Errorf("非法的时间窗口结束时间: %s", window.End)
+		}
+		rule.windows = append(rule.windows, windowRule)
+	}
+	for _, blackout := range calendar.Blackouts {
+		var start, end time.Time
+		if start, err = time.ParseInLocation("2006-01-02 15:04:05", blackout.Start, rule.location); err != nil {
+			return nil, fmt.Errorf("非法的封锁期开始时间: %s", blackout.Start)
+		}
+		if end, err = time.ParseInLocation("2006-01-02 15:04:05", blackout.End, rule.location); err != nil {
+			return nil, fmt.Errorf("非法的封锁期结束时间: %s", blackout.End)
+		}
+		if !start.Before(end) {
+			return nil, fmt.Errorf("封锁期的开始时间必须早于结束时间: %s - %s", blackout.Start, blackout.End)
+		}
+		rule.blackouts = append(rule.blackouts, [2]time.Time{start, end})
+	}
+	return
+}
+
+// parse the clock such as 15:04 to the minutes of the day
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// check the time is excluded by the calendar, return the reason if excluded
+func (rule *calendarRule) excludes(t time.Time) (bool, string) {
+	t = t.In(rule.location)
+	if date := t.Format("2006-01-02"); rule.holidays[date] {
+		return true, "节假日 " + date
+	}
+	for _, blackout := range rule.blackouts {
+		if !t.Before(blackout[0]) && t.Before(blackout[1]) {
+			return true, fmt.Sprintf("封锁期 %s - %s", ToDateString(blackout[0]), ToDateString(blackout[1]))
+		}
+	}
+	if len(rule.windows) == 0 {
+		return false, ""
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	for _, window := range rule.windows {
+		if !window.weekdays[t.Weekday()] {
+			continue
+		}
+		if window.start <= window.end {
+			if minutes >= window.start && minutes < window.end {
+				return false, ""
+			}
+		} else if minutes >= window.start || minutes < window.end {
+			return false, ""
+		}
+	}
+	return true, "不在允许的时间窗口内"
+}
+
+// the first time allowed by the calendar from the time, the zero time if not found
+func (rule *calendarRule) nextAllowed(t time.Time) time.Time {
+	limit := t.Add(calendarDeferLimit)
+	for t.Before(limit) {
+		if excluded, _ := rule.excludes(t); !excluded {
+			return t
+		}
+		t = rule.skip(t)
+	}
+	return time.Time{}
+}
+
+// the next time after the excluded time which may be allowed: the next day of the holiday,
+// the end of the blackout or the start of the next window
+func (rule *calendarRule) skip(t time.Time) time.Time {
+	local := t.In(rule.location)
+	year, month, day := local.Date()
+	if rule.holidays[local.Format("2006-01-02")] {
+		return time.Date(year, month, day+1, 0, 0, 0, 0, rule.location)
+	}
+	for _, blackout := range rule.blackouts {
+		if !t.Before(blackout[0]) && t.Before(blackout[1]) {
+			return blackout[1]
+		}
+	}
+	var next time.Time
+	for days := 0; days <= 7; days++ {
+		date := time.Date(year, month, day+days, 0, 0, 0, 0, rule.location)
+		for _, window := range rule.windows {
+			if !window.weekdays[date.Weekday()] {
+				continue
+			}
+			start := time.Date(year, month, day+days, window.start/60, window.start%60, 0, 0, rule.location)
+			if start.After(local) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	// never allowed by the windows
+	return t.Add(calendarDeferLimit)
+}
+
+// JobCalendars the calendars watched from etcd
+type JobCalendars struct {
+	node  *JobNode
+	rules map[string]*calendarRule
+	lk    *sync.RWMutex
+}
+
+func NewJobCalendars(node *JobNode) (calendars *JobCalendars) {
+	calendars = &JobCalendars{
+		node:  node,
+		rules: make(map[string]*calendarRule),
+		lk:    &sync.RWMutex{},
+	}
+	go calendars.watch()
+	return
+}
+
+func (calendars *JobCalendars) watch() {
+	keyChangeEventResponse := calendars.node.etcd.WatchWithPrefixKey(CalendarPath)
+	calendars.load()
+	for event := range keyChangeEventResponse.Event {
+		switch event.Type {
+		case etcdevent.KeyCreateChangeEvent, etcdevent.KeyUpdateChangeEvent:
+			calendars.put(event.Value)
+		case etcdevent.KeyDeleteChangeEvent:
+			calendars.lk.Lock()
+			delete(calendars.rules, strings.TrimPrefix(event.Key, CalendarPath))
+			calendars.lk.Unlock()
+		}
+	}
+}
+
+func (calendars *JobCalendars) load() {
+	_, values, err := calendars.node.etcd.GetWithPrefixKey(CalendarPath)
+	if err != nil {
+		log.Errorf("load the calendars error: %v", err)
+		return
+	}
+	for _, value := range values {
+		calendars.put(value)
+	}
+}
+
+func (calendars *JobCalendars) put(value []byte) {
+	calendar, err := UnpackCalendar(value)
+	if err != nil {
+		log.Errorf("unpack the calendar error: %#v", err)
+		return
+	}
+	rule, err := compileCalendar(calendar)
+	if err != nil {
+		log.Errorf("compile the calendar: %s error: %v", calendar.Name, err)
+		return
+	}
+	calendars.lk.Lock()
+	calendars.rules[calendar.Name] = rule
+	calendars.lk.Unlock()
+}
+
+func (calendars *JobCalendars) get(name string) *calendarRule {
+	calendars.lk.RLock()
+	defer calendars.lk.RUnlock()
+	return calendars.rules[name]
+}
+
+// check the fire time of the plan by the calendar, return the time to defer to
+// or the reason to skip, the zero time and empty reason means fire it now
+func (sch *JobScheduler) checkCalendar(plan *SchedulePlan, scheduleTime time.Time) (deferTime time.Time, reason string) {
+	if len(plan.Calendar) == 0 {
+		return
+	}
+	rule := sch.node.calendars.get(plan.Calendar)
+	if rule == nil {
+		log.Warnf("the calendar: %s of the plan: %s not found", plan.Calendar, plan.Id)
+		return
+	}
+	excluded, reason := rule.excludes(scheduleTime)
+	if !excluded {
+		return
+	}
+	if plan.CalendarPolicy == CalendarDefer {
+		if deferTime = rule.nextAllowed(scheduleTime); !deferTime.IsZero() {
+			return deferTime, ""
+		}
+	}
+	return time.Time{}, "日历排除: " + reason
+}
+
+// AddCalendar add calendar
+func (manager *JobManager) AddCalendar(calendar *Calendar) (err error) {
+	var (
+		value   []byte
+		success bool
+	)
+	if len(calendar.Name) == 0 {
+		err = errors.New("日历名称不能为空")
+		return
+	}
+	if _, err = compileCalendar(calendar); err != nil {
+		return
+	}
+	if value, err = PackCalendar(calendar); err != nil {
+		return
+	}
+	if success, _, err = manager.node.etcd.PutNotExist(CalendarPath+calendar.Name, string(value)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("此日历已存在")
+	}
+	return
+}
+
+// EditCalendar edit calendar
+func (manager *JobManager) EditCalendar(calendar *Calendar) (err error) {
+	var (
+		value   []byte
+		newV    []byte
+		success bool
+	)
+	if value, err = manager.node.etcd.Get(CalendarPath + calendar.Name); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此日历不存在")
+		return
+	}
+	if _, err = compileCalendar(calendar); err != nil {
+		return
+	}
+	if newV, err = PackCalendar(calendar); err != nil {
+		return
+	}
+	if success, err = manager.node.etcd.Update(CalendarPath+calendar.Name, string(newV), string(value)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("修改失败,请重试！")
+	}
+	return
+}
+
+// DeleteCalendar delete the calendar not used by any job
+func (manager *JobManager) DeleteCalendar(calendar *Calendar) (err error) {
+	var (
+		value    []byte
+		jobConfs []*JobConf
+	)
+	if len(calendar.Name) == 0 {
+		err = errors.New("此日历不存在")
+		return
+	}
+	if value, err = manager.node.etcd.Get(CalendarPath + calendar.Name); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此日历不存在")
+		return
+	}
+	if jobConfs, err = manager.JobList(); err != nil {
+		return
+	}
+	for _, jobConf := range jobConfs {
+		if jobConf.Calendar == calendar.Name {
+			err = fmt.Errorf("此日历正在被任务使用: %s", jobConf.Name)
+			return
+		}
+	}
+	err = manager.node.etcd.Delete(CalendarPath + calendar.Name)
+	return
+}
+
+// CalendarList calendar list
+func (manager *JobManager) CalendarList() (calendars []*Calendar, err error) {
+	var values [][]byte
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(CalendarPath); err != nil {
+		return
+	}
+	calendars = make([]*Calendar, 0, len(values))
+	for _, value := range values {
+		calendar, err := UnpackCalendar(value)
+		if err != nil {
+			log.Errorf("unpack the calendar error: %#v", err)
+			continue
+		}
+		calendars = append(calendars, calendar)
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package forest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarRule(t *testing.T) {
+	rule, err := compileCalendar(&Calendar{
+		Timezone:  "UTC",
+		Holidays:  []string{"2026-10-01"},
+		Windows:   []*CalendarWindow{{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "18:00"}},
+		Blackouts: []*CalendarBlackout{{Start: "2026-10-08 12:00:00", End: "2026-10-08 14:00:00"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		time     time.Time
+		excluded bool
+	}{
+		{time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC), true},  // the holiday
+		{time.Date(2026, 10, 2, 10, 0, 0, 0, time.UTC), false}, // Friday
+		{time.Date(2026, 10, 2, 18, 0, 0, 0, time.UTC), true},  // out of the window
+		{time.Date(2026, 10, 3, 10, 0, 0, 0, time.UTC), true},  // Saturday
+		{time.Date(2026, 10, 8, 13, 0, 0, 0, time.UTC), true},  // the blackout
+	}
+	for _, c := range cases {
+		if excluded, reason := rule.excludes(c.time); excluded != c.excluded {
+			t.Fatalf("the time: %v excluded: %v, expected %v, reason: %s", c.time, excluded, c.excluded, reason)
+		}
+	}
+
+	next := rule.nextAllowed(time.Date(2026, 10, 2, 20, 30, 0, 0, time.UTC))
+	if expected := time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Fatalf("the next allowed time: %v, expected %v", next, expected)
+	}
+
+	for from, expected := range map[time.Time]time.Time{
+		time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC):  time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC),  // the holiday
+		time.Date(2026, 10, 8, 12, 30, 0, 0, time.UTC): time.Date(2026, 10, 8, 14, 0, 0, 0, time.UTC), // the end of the blackout
+		time.Date(2026, 10, 8, 7, 15, 0, 0, time.UTC):  time.Date(2026, 10, 8, 9, 0, 0, 0, time.UTC),  // the start of the window
+		time.Date(2026, 10, 8, 15, 0, 0, 0, time.UTC):  time.Date(2026, 10, 8, 15, 0, 0, 0, time.UTC), // allowed
+	} {
+		if next := rule.nextAllowed(from); !next.Equal(expected) {
+			t.Fatalf("the next allowed time from %v: %v, expected %v", from, next, expected)
+		}
+	}
+	never, err := compileCalendar(&Calendar{Timezone: "UTC", Windows: []*CalendarWindow{{Start: "09:00", End: "09:00"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := never.nextAllowed(time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Fatalf("the calendar never allowed found: %v", next)
+	}
+
+	if _, err = compileCalendar(&Calendar{Windows: []*CalendarWindow{{Start: "25:00", End: "18:00"}}}); err == nil {
+		t.Fatal("the invalid window compiled")
+	}
+}
@@ -0,0 +1,122 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/admpub/log"
+	"github.com/andistributed/etcd/etcdevent"
+)
+
+// the clients publish the capacity and the running count in the registration value, the group
+// track the snapshots given to the clients and only select the clients which have free slots
+
+// ErrNoFreeSlot no client of the group has free slot
+var ErrNoFreeSlot = errors.New("no client has free slot")
+
+// the used slots of the client, must hold the lock
+func (group *Group) usedSlots(c *Client) int {
+	used := len(group.assigned[c.name])
+	if c.meta != nil && c.meta.Running > used {
+		used = c.meta.Running
+	}
+	return used
+}
+
+// check the client has free slot, the client not report the capacity always has, must hold the lock
+func (group *Group) hasFreeSlot(c *Client) bool {
+	if c.meta == nil || c.meta.Capacity <= 0 {
+		return true
+	}
+	return group.usedSlots(c) < c.meta.Capacity
+}
+
+// the clients which have free slots, must hold the lock
+func (group *Group) freeClients(clients []*Client) ([]*Client, error) {
+	free := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		if group.hasFreeSlot(c) {
+			free = append(free, c)
+		}
+	}
+	if len(free) == 0 {
+		return nil, fmt.Errorf("the group: %s, %w", group.name, ErrNoFreeSlot)
+	}
+	return free, nil
+}
+
+// take a slot of the client for the snapshot, must hold the lock
+func (group *Group) assign(clientName string, snapshotId string) {
+	snapshots, ok := group.assigned[clientName]
+	if !ok {
+		snapshots = make(map[string]bool)
+		group.assigned[clientName] = snapshots
+	}
+	snapshots[snapshotId] = true
+}
+
+// free the slot of the client taken by the snapshot, must hold the lock
+func (group *Group) unassign(clientName string, snapshotId string) {
+	if snapshots, ok := group.assigned[clientName]; ok {
+		delete(snapshots, snapshotId)
+		if len(snapshots) == 0 {
+			delete(group.assigned, clientName)
+		}
+	}
+}
+
+// free the slot of the client which failed to dispatch the snapshot
+func (group *Group) releaseSlot(clientName string, snapshotId string) {
+	group.lk.Lock()
+	group.unassign(clientName, snapshotId)
+	group.lk.Unlock()
+}
+
+// watch the snapshots given to the clients of the group
+func (group *Group) watchSnapshotPath() {
+	keyChangeEventResponse := group.node.etcd.WatchWithPrefixKey(group.snapshotPath)
+	group.snapshotWatcher = keyChangeEventResponse.Watcher
+	group.snapshotCancelFunc = keyChangeEventResponse.CancelFunc
+	group.loadSnapshots()
+	for event := range keyChangeEventResponse.Event {
+		clientName, snapshotId := group.parseSnapshotKey(event.Key)
+		if len(snapshotId) == 0 {
+			continue
+		}
+		switch event.Type {
+		case etcdevent.KeyCreateChangeEvent:
+			group.lk.Lock()
+			group.assign(clientName, snapshotId)
+			group.lk.Unlock()
+		case etcdevent.KeyDeleteChangeEvent:
+			group.releaseSlot(clientName, snapshotId)
+			// a slot is free for the waiting snapshots
+			go group.node.limiter.release(group.name)
+		}
+	}
+}
+
+func (group *Group) loadSnapshots() {
+	keys, _, err := group.node.etcd.GetWithPrefixKey(group.snapshotPath)
+	if err != nil {
+		log.Errorf("the group: %s load the snapshots of the clients error: %v", group.name, err)
+		return
+	}
+	group.lk.Lock()
+	defer group.lk.Unlock()
+	for _, key := range keys {
+		if clientName, snapshotId := group.parseSnapshotKey(string(key)); len(snapshotId) > 0 {
+			group.assign(clientName, snapshotId)
+		}
+	}
+}
+
+// parse the client name and the snapshot id from the key under the snapshot path of the group
+func (group *Group) parseSnapshotKey(key string) (clientName string, snapshotId string) {
+	parts := strings.SplitN(strings.TrimPrefix(key, group.snapshotPath), "/", 2)
+	if len(parts) != 2 {
+		return
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,27 @@
+package forest
+
+import "testing"
+
+func TestGroupFreeClients(t *testing.T) {
+	group := &Group{name: "g", snapshotPath: "/forest/client/snapshot/g/", assigned: make(map[string]map[string]bool)}
+	a := &Client{name: "a", meta: &ClientMeta{Name: "a", Capacity: 2}}
+	b := &Client{name: "b", meta: &ClientMeta{Name: "b", Capacity: 1, Running: 1}}
+	c := &Client{name: "c"}
+	clientName, snapshotId := group.parseSnapshotKey("/forest/client/snapshot/g/a/s1")
+	if clientName != "a" || snapshotId != "s1" {
+		t.Fatalf("the client: %s, the snapshot: %s", clientName, snapshotId)
+	}
+	group.assign(clientName, snapshotId)
+	group.assign("a", "s2")
+	free, err := group.freeClients([]*Client{a, b, c})
+	if err != nil || len(free) != 1 || free[0] != c {
+		t.Fatalf("the free clients: %v, %v", free, err)
+	}
+	if _, err = group.freeClients([]*Client{a, b}); err == nil {
+		t.Fatal("the full clients not detected")
+	}
+	group.unassign("a", "s1")
+	if !group.hasFreeSlot(a) || group.usedSlots(a) != 1 {
+		t.Fatalf("the slot not released: %d", group.usedSlots(a))
+	}
+}
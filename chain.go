@@ -0,0 +1,118 @@
+package forest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// fire the downstream jobs of the finished execute snapshot by the job chaining
+func (c *JobCollection) triggerDownstream(snapshot *JobExecuteSnapshot) {
+	if len(snapshot.JobId) == 0 {
+		return
+	}
+	conf, err := c.node.manager.GetJob(snapshot.JobId)
+	if err != nil {
+		log.Warnf("the snapshot: %s load the job conf: %s error: %v", snapshot.Id, snapshot.JobId, err)
+		return
+	}
+	for _, jobId := range conf.statusDownstreams(snapshot.Status) {
+		downstreamConf, err := c.node.manager.GetJob(jobId)
+		if err != nil {
+			log.Warnf("the snapshot: %s load the downstream job conf: %s error: %v", snapshot.Id, jobId, err)
+			continue
+		}
+		if downstreamConf.Status != JobRunningStatus {
+			log.Warnf("the snapshot: %s skip the stopped downstream job: %s", snapshot.Id, jobId)
+			continue
+		}
+		downstream := c.node.manager.newJobSnapshot(downstreamConf, time.Now())
+		downstream.UpstreamId = snapshot.Id
+		downstream.UpstreamStatus = snapshot.Status
+		downstream.UpstreamResult = snapshot.Result
+		log.Infof("the snapshot: %s of the job: %s fire the downstream job: %s as the snapshot: %s", snapshot.Id, snapshot.JobId, jobId, downstream.Id)
+		if err = c.node.manager.ManualExecute(downstream); err != nil {
+			log.Errorf("fire the downstream snapshot: %s error: %v", downstream.Id, err)
+		}
+	}
+}
+
+// the downstream job ids fired by the status of the execution, the skipped
+// and the dropped executions never run so they fire nothing
+func (conf *JobConf) statusDownstreams(status int) []string {
+	switch status {
+	case JobExecuteSnapshotSuccessStatus:
+		return conf.OnSuccess
+	case JobExecuteSnapshotSkippedStatus, JobExecuteSnapshotDroppedStatus:
+		return nil
+	default:
+		return conf.OnFailure
+	}
+}
+
+// the downstream job ids of the job conf
+func (conf *JobConf) downstreams() []string {
+	return append(append([]string{}, conf.OnSuccess...), conf.OnFailure...)
+}
+
+// check the downstream jobs exist and the chaining has no cycle
+func (manager *JobManager) checkJobChain(jobConf *JobConf) (err error) {
+	downstreams := jobConf.downstreams()
+	if len(downstreams) == 0 {
+		return
+	}
+	var jobConfs []*JobConf
+	if jobConfs, err = manager.JobList(); err != nil {
+		return
+	}
+	graph := make(map[string][]string, len(jobConfs)+1)
+	for _, conf := range jobConfs {
+		graph[conf.Id] = conf.downstreams()
+	}
+	for _, jobId := range downstreams {
+		if _, ok := graph[jobId]; !ok {
+			err = fmt.Errorf("下游任务不存在: %s", jobId)
+			return
+		}
+	}
+	if len(jobConf.Id) == 0 {
+		// the new job has no upstream yet
+		return
+	}
+	graph[jobConf.Id] = downstreams
+	if cycle := findJobChainCycle(jobConf.Id, graph); len(cycle) > 0 {
+		err = fmt.Errorf("任务链存在循环依赖: %s", strings.Join(cycle, " -> "))
+	}
+	return
+}
+
+// find the cycle back to the job along the downstreams, return the path of the cycle
+func findJobChainCycle(jobId string, graph map[string][]string) []string {
+	visited := make(map[string]bool)
+	var path []string
+	var walk func(id string) bool
+	walk = func(id string) bool {
+		path = append(path, id)
+		for _, next := range graph[id] {
+			if next == jobId {
+				path = append(path, next)
+				return true
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			if walk(next) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if walk(jobId) {
+		return path
+	}
+	return nil
+}
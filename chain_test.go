@@ -0,0 +1,44 @@
+package forest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindJobChainCycle(t *testing.T) {
+	graph := map[string][]string{
+		"a": {"b", "c"},
+		"b": {"d"},
+		"c": {"d"},
+		"d": {},
+	}
+	if cycle := findJobChainCycle("a", graph); cycle != nil {
+		t.Fatalf("unexpected cycle: %v", cycle)
+	}
+	graph["d"] = []string{"a"}
+	if cycle := findJobChainCycle("a", graph); !reflect.DeepEqual(cycle, []string{"a", "b", "d", "a"}) {
+		t.Fatalf("the cycle: %v", cycle)
+	}
+	graph["a"] = []string{"a"}
+	if cycle := findJobChainCycle("a", graph); !reflect.DeepEqual(cycle, []string{"a", "a"}) {
+		t.Fatalf("the cycle: %v", cycle)
+	}
+}
+
+func TestJobConfStatusDownstreams(t *testing.T) {
+	conf := &JobConf{OnSuccess: []string{"s"}, OnFailure: []string{"f"}}
+	cases := map[int][]string{
+		JobExecuteSnapshotSuccessStatus:  {"s"},
+		JobExecuteSnapshotErrorStatus:    {"f"},
+		JobExecuteSnapshotTimeoutStatus:  {"f"},
+		JobExecuteSnapshotUnknownStatus:  {"f"},
+		JobExecuteSnapshotNoClientStatus: {"f"},
+		JobExecuteSnapshotSkippedStatus:  nil,
+		JobExecuteSnapshotDroppedStatus:  nil,
+	}
+	for status, expected := range cases {
+		if downstreams := conf.statusDownstreams(status); !reflect.DeepEqual(downstreams, expected) {
+			t.Errorf("the downstreams of the status: %d: %v, expected %v", status, downstreams, expected)
+		}
+	}
+}
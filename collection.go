@@ -91,30 +91,68 @@ func (c *JobCollection) handleJobExecuteStatusCollectionEvent(event *etcdevent.K
 func (c *JobCollection) handleJobExecuteSnapshot(path string, snapshot *JobExecuteSnapshot) {
 
 	var (
-		exist bool
-		err   error
+		old *JobExecuteSnapshot
+		err error
 	)
 
 	c.lk.Lock()
-	defer c.lk.Unlock()
-	if exist, err = c.checkExist(snapshot.Id); err != nil {
+	if old, err = c.findExecuteSnapshot(snapshot.Id); err != nil {
+		c.lk.Unlock()
 		log.Errorf("check snapshot exist error: %v", err)
 		return
 	}
 
-	if exist {
+	if old != nil {
+		snapshot.mergeDispatched(old)
+		if old.Status == JobExecuteSnapshotTimeoutStatus {
+			// keep the timeout status of the killed execution
+			snapshot.Status = JobExecuteSnapshotTimeoutStatus
+		}
 		c.handleUpdateJobExecuteSnapshot(path, snapshot)
 	} else {
 		c.handleCreateJobExecuteSnapshot(path, snapshot)
 	}
+	c.lk.Unlock()
+
+	if snapshot.Status == JobExecuteSnapshotDoingStatus && !snapshot.isShard() {
+		c.node.limiter.track(snapshot.Id, snapshot.Group, snapshot.JobId, snapshot.Ip)
+	}
+	if IsFinishedStatus(snapshot.Status) && (old == nil || !IsFinishedStatus(old.Status)) {
+		c.handleJobExecuteSnapshotFinished(snapshot)
+	}
+}
+
+// handle the execute snapshot which just finished, must not hold the lock
+func (c *JobCollection) handleJobExecuteSnapshotFinished(snapshot *JobExecuteSnapshot) {
+	if c.node.state != NodeLeaderState {
+		return
+	}
+	c.node.timeout.untrack(snapshot.Id)
+	c.node.limiter.done(snapshot.Id)
+	go c.node.limiter.release(snapshot.Group)
+	if snapshot.isShard() {
+		c.checkShards(snapshot.ShardParentId)
+		return
+	}
+	if retry := c.tryRetry(snapshot); retry != nil {
+		c.node.workflow.rebindNode(snapshot.Id, retry.Id)
+		return
+	}
+	c.node.workflow.handleSnapshotFinished(snapshot)
+	c.triggerDownstream(snapshot)
+	if len(snapshot.JobId) > 0 {
+		// the scheduler may record the snapshot itself, never block it
+		go c.node.scheduler.pushJobChangeEvent(&JobChangeEvent{
+			Type: JobExecuteFinishedChangeEvent,
+			Conf: &JobConf{Id: snapshot.JobId},
+		})
+	}
 }
 
 // handle create job execute snapshot
 func (c *JobCollection) handleCreateJobExecuteSnapshot(path string, snapshot *JobExecuteSnapshot) {
 
-	if snapshot.Status == JobExecuteSnapshotUnknownStatus ||
-		snapshot.Status == JobExecuteSnapshotErrorStatus ||
-		snapshot.Status == JobExecuteSnapshotSuccessStatus {
+	if IsFinishedStatus(snapshot.Status) {
 		err := c.node.etcd.Delete(path)
 		if err != nil {
 			log.Error(err)
@@ -141,9 +179,7 @@ func (c *JobCollection) handleCreateJobExecuteSnapshot(path string, snapshot *Jo
 // handle update job execute snapshot
 func (c *JobCollection) handleUpdateJobExecuteSnapshot(path string, snapshot *JobExecuteSnapshot) {
 
-	if snapshot.Status == JobExecuteSnapshotUnknownStatus ||
-		snapshot.Status == JobExecuteSnapshotErrorStatus ||
-		snapshot.Status == JobExecuteSnapshotSuccessStatus {
+	if IsFinishedStatus(snapshot.Status) {
 		err := c.node.etcd.Delete(path)
 		if err != nil {
 			log.Error(err)
@@ -169,15 +205,181 @@ func (c *JobCollection) handleUpdateJobExecuteSnapshot(path string, snapshot *Jo
 	}
 }
 
-// check the exist
-func (c *JobCollection) checkExist(id string) (exist bool, err error) {
-	exist, err = c.node.UseTable(TableJobExecuteSnapshot).Find(db.Cond{`id`: id}).Exists()
+// the doing execute snapshots of the job
+func (c *JobCollection) runningSnapshots(jobId string) (snapshots []*JobExecuteSnapshot, err error) {
+	var values [][]byte
+	if _, values, err = c.node.etcd.GetWithPrefixKey(JobExecuteStatusCollectionPath); err != nil {
+		return
+	}
+	for _, value := range values {
+		executeSnapshot, err := UnpackJobExecuteSnapshot(value)
+		if err != nil {
+			continue
+		}
+		if executeSnapshot.JobId == jobId && executeSnapshot.Status == JobExecuteSnapshotDoingStatus {
+			snapshots = append(snapshots, executeSnapshot)
+		}
+	}
+	return
+}
+
+// new a finished execute snapshot from the job snapshot
+func newExecuteSnapshot(snapshot *JobSnapshot, status int, result string) *JobExecuteSnapshot {
+	now := ToDateString(time.Now())
+	executeSnapshot := &JobExecuteSnapshot{
+		Id:         snapshot.Id,
+		JobId:      snapshot.JobId,
+		Name:       snapshot.Name,
+		Ip:         snapshot.Ip,
+		Group:      snapshot.Group,
+		Cron:       snapshot.Cron,
+		Target:     snapshot.Target,
+		Params:     snapshot.Params,
+		Remark:     snapshot.Remark,
+		CreateTime: snapshot.CreateTime,
+		StartTime:  now,
+		FinishTime: now,
+		Status:     status,
+		Result:     result,
+		Attempt:    snapshot.Attempt,
+		RetryOf:    snapshot.RetryOf,
+
+		ShardParentId: snapshot.ShardParentId,
+		ShardIndex:    snapshot.ShardIndex,
+		ShardTotal:    snapshot.ShardTotal,
+
+		LogicalTime: snapshot.LogicalTime,
+	}
+	if len(executeSnapshot.CreateTime) == 0 {
+		executeSnapshot.CreateTime = now
+	}
+	return executeSnapshot
+}
+
+// record the snapshot before given to the client, the leader keep the retry attempt, the shard and the
+// logical time of the execution in the record instead of trusting the report of the client, the record of the snapshot dispatched
+// again only follow the new client
+func (c *JobCollection) recordDispatched(snapshot *JobSnapshot) (err error) {
+	var old *JobExecuteSnapshot
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if old, err = c.findExecuteSnapshot(snapshot.Id); err != nil {
+		return
+	}
+	if old == nil {
+		executeSnapshot := newExecuteSnapshot(snapshot, JobExecuteSnapshotDoingStatus, "")
+		executeSnapshot.StartTime = ""
+		executeSnapshot.FinishTime = ""
+		_, err = c.node.UseTable(TableJobExecuteSnapshot).Insert(executeSnapshot)
+		return
+	}
+	old.Ip = snapshot.Ip
+	return c.node.UseTable(TableJobExecuteSnapshot).Find(db.Cond{`id`: old.Id}).Update(old)
+}
+
+// remove the record of the snapshot failed to give to the client
+func (c *JobCollection) removeDispatched(id string) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	err := c.node.UseTable(TableJobExecuteSnapshot).
+		Find(db.Cond{`id`: id, `status`: JobExecuteSnapshotDoingStatus, `start_time`: ``}).
+		Delete()
+	if err != nil {
+		log.Errorf("remove the record of the snapshot: %s error: %v", id, err)
+	}
+}
+
+// keep the fields of the execution recorded by the leader when dispatched, the clients may not report them
+func (s *JobExecuteSnapshot) mergeDispatched(old *JobExecuteSnapshot) {
+	s.Attempt = old.Attempt
+	s.RetryOf = old.RetryOf
+	s.ShardParentId = old.ShardParentId
+	s.ShardIndex = old.ShardIndex
+	s.ShardTotal = old.ShardTotal
+	s.LogicalTime = old.LogicalTime
+}
+
+// the finished execute snapshot to record, the new one if the execution never recorded,
+// the updated old one if it is still doing, nil if it has already finished
+func finishExecuteSnapshot(old *JobExecuteSnapshot, snapshot *JobSnapshot, status int, result string) *JobExecuteSnapshot {
+	executeSnapshot := newExecuteSnapshot(snapshot, status, result)
+	if old == nil {
+		return executeSnapshot
+	}
+	if old.Status != JobExecuteSnapshotDoingStatus {
+		return nil
+	}
+	old.Status = executeSnapshot.Status
+	old.FinishTime = executeSnapshot.FinishTime
+	if len(old.StartTime) == 0 {
+		old.StartTime = executeSnapshot.StartTime
+	}
+	old.Result = result
+	return old
+}
+
+// record the execution of the snapshot finished by the leader, the snapshot may be recorded
+// when dispatched, or never given to the client
+func (c *JobCollection) finishJobSnapshot(snapshot *JobSnapshot, status int, result string) *JobExecuteSnapshot {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	old, err := c.findExecuteSnapshot(snapshot.Id)
+	if err != nil {
+		log.Errorf("check snapshot exist error: %v", err)
+		return nil
+	}
+	executeSnapshot := finishExecuteSnapshot(old, snapshot, status, result)
+	if old == nil {
+		_, err = c.node.UseTable(TableJobExecuteSnapshot).Insert(executeSnapshot)
+	} else if executeSnapshot != nil {
+		err = c.node.UseTable(TableJobExecuteSnapshot).Find(db.Cond{`id`: old.Id}).Update(executeSnapshot)
+	}
+	if err != nil {
+		log.Errorf("record the snapshot: %s status: %d error: %v", snapshot.Id, status, err)
+		return nil
+	}
+	return executeSnapshot
+}
+
+// record a job snapshot which finished without started by the client, and handle it
+// as the other finished executions so the retry, the chaining and the workflow go on
+func (c *JobCollection) recordJobSnapshot(snapshot *JobSnapshot, status int, result string) {
+	if executeSnapshot := c.finishJobSnapshot(snapshot, status, result); executeSnapshot != nil {
+		c.handleJobExecuteSnapshotFinished(executeSnapshot)
+	}
+}
+
+// mark the execution of the snapshot timeout
+func (c *JobCollection) markTimeout(snapshot *JobSnapshot, result string) {
+	c.recordJobSnapshot(snapshot, JobExecuteSnapshotTimeoutStatus, result)
+}
+
+// the finish time of the last finished execution of the job
+func (c *JobCollection) lastFinishTime(jobId string) (finishTime time.Time, err error) {
+	snapshot := new(JobExecuteSnapshot)
+	err = c.node.UseTable(TableJobExecuteSnapshot).
+		Find(db.Cond{`job_id`: jobId, `finish_time <>`: ``}).
+		OrderBy(`-finish_time`).
+		One(snapshot)
 	if err != nil {
 		if err == db.ErrNoMoreRows {
 			err = nil
 		}
 		return
 	}
+	return time.ParseInLocation("2006-01-02 15:04:05", snapshot.FinishTime, time.Local)
+}
+
+// find the execute snapshot, return nil if not exist
+func (c *JobCollection) findExecuteSnapshot(id string) (snapshot *JobExecuteSnapshot, err error) {
+	snapshot = new(JobExecuteSnapshot)
+	err = c.node.UseTable(TableJobExecuteSnapshot).Find(db.Cond{`id`: id}).One(snapshot)
+	if err != nil {
+		snapshot = nil
+		if err == db.ErrNoMoreRows {
+			err = nil
+		}
+	}
 	return
 }
 
@@ -212,9 +414,7 @@ func (c *JobCollection) loop() {
 					continue
 				}
 
-				if executeSnapshot.Status == JobExecuteSnapshotSuccessStatus ||
-					executeSnapshot.Status == JobExecuteSnapshotErrorStatus ||
-					executeSnapshot.Status == JobExecuteSnapshotUnknownStatus {
+				if IsFinishedStatus(executeSnapshot.Status) {
 					path := string(key)
 					c.handleJobExecuteSnapshot(path, executeSnapshot)
 				}
@@ -0,0 +1,225 @@
+package forest
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/andistributed/etcd/etcdevent"
+)
+
+// the cordoned clients keep running the executions already given but not selected for the new
+// snapshots, the cordon state saved in etcd so all the nodes skip the same clients, the leader
+// move the snapshots of the draining client in the background and clear the drain flag after moved
+
+const (
+	JobCordonPath = "/forest/server/cordon/" // + group/client.name
+)
+
+// drainTimeout the max duration to retry loading the snapshots of the draining client
+const drainTimeout = time.Minute
+
+// watch the cordoned clients of the group
+func (group *Group) watchCordonPath() {
+	keyChangeEventResponse := group.node.etcd.WatchWithPrefixKey(group.cordonPath)
+	group.cordonWatcher = keyChangeEventResponse.Watcher
+	group.cordonCancelFunc = keyChangeEventResponse.CancelFunc
+	group.loadCordons()
+	for event := range keyChangeEventResponse.Event {
+		clientName := strings.TrimPrefix(event.Key, group.cordonPath)
+		switch event.Type {
+		case etcdevent.KeyCreateChangeEvent, etcdevent.KeyUpdateChangeEvent:
+			group.setCordoned(clientName, true)
+			if cordon, err := UnpackCordon(event.Value); err == nil && cordon.Drain && group.node.state == NodeLeaderState {
+				go group.drain(clientName, event.Value)
+			}
+		case etcdevent.KeyDeleteChangeEvent:
+			group.setCordoned(clientName, false)
+			// the client can receive the waiting and pending snapshots again
+			go group.node.limiter.release(group.name)
+			go group.node.pending.drain(group.name)
+		}
+	}
+}
+
+func (group *Group) loadCordons() {
+	keys, _, err := group.node.etcd.GetWithPrefixKey(group.cordonPath)
+	if err != nil {
+		log.Errorf("the group: %s load the cordoned clients error: %v", group.name, err)
+		return
+	}
+	group.lk.Lock()
+	defer group.lk.Unlock()
+	for _, key := range keys {
+		group.cordoned[strings.TrimPrefix(string(key), group.cordonPath)] = true
+	}
+}
+
+func (group *Group) setCordoned(clientName string, cordoned bool) {
+	group.lk.Lock()
+	defer group.lk.Unlock()
+	if cordoned {
+		group.cordoned[clientName] = true
+		log.Infof("the group: %s, cordon the client: %s", group.name, clientName)
+	} else {
+		delete(group.cordoned, clientName)
+		log.Infof("the group: %s, uncordon the client: %s", group.name, clientName)
+	}
+}
+
+// move the snapshots not started yet of the draining client to the other clients on the leader,
+// the malformed snapshots are dropped by the transfer so the drain always reach the end
+func (group *Group) drain(clientName string, value []byte) {
+	log.Infof("the group: %s, drain the client: %s", group.name, clientName)
+	if err := group.node.failOver.transfer(group, clientName, time.Now().Add(drainTimeout)); err != nil {
+		log.Errorf("the group: %s, drain the client: %s error: %v", group.name, clientName, err)
+		return
+	}
+	cordon, err := UnpackCordon(value)
+	if err != nil {
+		log.Errorf("the group: %s, unpack the cordon of the client: %s error: %v", group.name, clientName, err)
+		return
+	}
+	cordon.Drain = false
+	drained, err := PackCordon(cordon)
+	if err != nil {
+		log.Errorf("the group: %s, pack the cordon of the client: %s error: %v", group.name, clientName, err)
+		return
+	}
+	// keep the client cordoned, the update fails if the client uncordoned or drained again
+	if _, err = group.node.etcd.Update(group.cordonPath+clientName, string(drained), string(value)); err != nil {
+		log.Errorf("the group: %s, clear the drain of the client: %s error: %v", group.name, clientName, err)
+	}
+}
+
+// resume draining the clients when the node become the leader, the previous leader may not finish them
+func (manager *JobGroupManager) notify(state int) {
+	if state != NodeLeaderState {
+		return
+	}
+	manager.lk.RLock()
+	groups := make([]*Group, 0, len(manager.groups))
+	for _, group := range manager.groups {
+		groups = append(groups, group)
+	}
+	manager.lk.RUnlock()
+	for _, group := range groups {
+		keys, values, err := manager.node.etcd.GetWithPrefixKey(group.cordonPath)
+		if err != nil {
+			log.Errorf("the group: %s load the cordoned clients error: %v", group.name, err)
+			continue
+		}
+		for index, key := range keys {
+			cordon, err := UnpackCordon(values[index])
+			if err != nil {
+				log.Errorf("the group: %s unpack the cordon: %s error: %v", group.name, key, err)
+				continue
+			}
+			if cordon.Drain {
+				go group.drain(strings.TrimPrefix(string(key), group.cordonPath), values[index])
+			}
+		}
+	}
+}
+
+// check the client registered in the group
+func (group *Group) hasClient(name string) bool {
+	group.lk.RLock()
+	defer group.lk.RUnlock()
+	for _, c := range group.clients {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// the group of the cordon
+func (manager *JobManager) cordonGroup(cordon *Cordon) (group *Group, err error) {
+	var ok bool
+	if len(cordon.Group) == 0 {
+		err = errors.New("请选择任务集群")
+		return
+	}
+	if len(cordon.Client) == 0 {
+		err = errors.New("客户端名称不能为空")
+		return
+	}
+	manager.node.groupManager.lk.RLock()
+	group, ok = manager.node.groupManager.groups[GroupConfPath+cordon.Group]
+	manager.node.groupManager.lk.RUnlock()
+	if !ok {
+		err = errors.New("此任务集群不存在")
+	}
+	return
+}
+
+// CordonClient stop selecting the client for the new snapshots, the leader move the snapshots
+// not started yet of the client to the other clients in the background when drain
+func (manager *JobManager) CordonClient(cordon *Cordon) (err error) {
+	var (
+		group *Group
+		value []byte
+	)
+	if group, err = manager.cordonGroup(cordon); err != nil {
+		return
+	}
+	if !group.hasClient(cordon.Client) {
+		err = errors.New("此客户端未在任务集群中注册")
+		return
+	}
+	cordon.CreateTime = ToDateString(time.Now())
+	if value, err = PackCordon(cordon); err != nil {
+		return
+	}
+	err = manager.node.etcd.Put(group.cordonPath+cordon.Client, string(value))
+	return
+}
+
+// DrainClient cordon the client and move the snapshots not started yet of the client to the other clients
+func (manager *JobManager) DrainClient(cordon *Cordon) (err error) {
+	cordon.Drain = true
+	return manager.CordonClient(cordon)
+}
+
+// UncordonClient select the client for the new snapshots again
+func (manager *JobManager) UncordonClient(cordon *Cordon) (err error) {
+	var (
+		group *Group
+		value []byte
+	)
+	if group, err = manager.cordonGroup(cordon); err != nil {
+		return
+	}
+	if value, err = manager.node.etcd.Get(group.cordonPath + cordon.Client); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此客户端未被停止调度")
+		return
+	}
+	return manager.node.etcd.Delete(group.cordonPath + cordon.Client)
+}
+
+// CordonList the cordoned clients of the group
+func (manager *JobManager) CordonList(group string) (cordons []*Cordon, err error) {
+	var values [][]byte
+	prefix := JobCordonPath
+	if len(group) > 0 {
+		prefix += group + "/"
+	}
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(prefix); err != nil {
+		return
+	}
+	cordons = make([]*Cordon, 0, len(values))
+	for _, value := range values {
+		cordon, err := UnpackCordon(value)
+		if err != nil {
+			log.Errorf("unpack the cordon error: %#v", err)
+			continue
+		}
+		cordons = append(cordons, cordon)
+	}
+	return
+}
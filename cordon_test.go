@@ -0,0 +1,33 @@
+package forest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestGroupCandidatesSkipCordoned(t *testing.T) {
+	group := &Group{
+		name: "test",
+		clients: map[string]*Client{
+			"/a": {name: "a", path: "/a"},
+			"/b": {name: "b", path: "/b"},
+		},
+		cordoned: map[string]bool{"a": true},
+		lk:       &sync.RWMutex{},
+	}
+	clients, err := group.candidates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].name != "b" {
+		t.Fatalf("the cordoned client should be skipped, got: %v", clients)
+	}
+	group.cordoned["b"] = true
+	if _, err = group.candidates(nil); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("all the clients are cordoned, got: %v", err)
+	}
+	if !group.hasClient("a") || group.hasClient("/a") || group.hasClient("c") {
+		t.Fatal("only the registered clients can be cordoned")
+	}
+}
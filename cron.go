@@ -0,0 +1,418 @@
+package forest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/robfig/cron"
+)
+
+// the extended cron expression:
+//
+//	[second] minute hour day-of-month month day-of-week
+//
+// the seconds field is optional, the 5 fields expression runs at the second 0.
+// the day of month supports L (the last day), LW (the last weekday) and 15W (the weekday nearest the 15th),
+// the day of week supports 5L (the last Friday of the month) and 5#3 (the third Friday of the month),
+// the macros such as @daily, @hourly and @every 1h30m are supported as well
+
+// CronVersion the current semantics of the cron expression, the job conf saved before it use the 5 fields
+// expression as `second minute hour day-of-month month` which is kept until the job saved again
+const CronVersion = 1
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+type cronBounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSecond = cronBounds{name: "second", min: 0, max: 59}
+	cronMinute = cronBounds{name: "minute", min: 0, max: 59}
+	cronHour   = cronBounds{name: "hour", min: 0, max: 23}
+	cronDom    = cronBounds{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronBounds{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronBounds{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// CronSchedule the schedule of the extended cron expression
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+
+	// the day of month or the day of week is * or ?, the day matches both of them,
+	// otherwise the day matches either of them
+	domStar, dowStar bool
+
+	lastDay         bool     // L
+	lastWeekday     bool     // LW
+	nearestWeekdays []int    // 15W
+	lastDows        []int    // 5L
+	nthDows         [][2]int // 5#3: the day of week and the nth
+}
+
+// ParseCron parse the extended cron expression
+func ParseCron(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("empty cron expression")
+	}
+	if strings.HasPrefix(spec, "@") {
+		if strings.HasPrefix(spec, "@every ") {
+			duration, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration of %s: %v", spec, err)
+			}
+			if duration < time.Second {
+				return nil, fmt.Errorf("the duration of %s must be at least 1s", spec)
+			}
+			return cron.Every(duration), nil
+		}
+		macro, ok := cronMacros[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown macro: %s", spec)
+		}
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields, found %d: %s", len(fields), spec)
+	}
+
+	var err error
+	s := &CronSchedule{}
+	if s.second, err = parseCronField(fields[0], cronSecond); err != nil {
+		return nil, err
+	}
+	if s.minute, err = parseCronField(fields[1], cronMinute); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[2], cronHour); err != nil {
+		return nil, err
+	}
+	if err = s.parseDom(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[4], cronMonth); err != nil {
+		return nil, err
+	}
+	if err = s.parseDow(fields[5]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parse the cron expression of the job conf by the semantics the conf saved with
+func parseJobCron(jobConf *JobConf) (cron.Schedule, error) {
+	spec := strings.TrimSpace(jobConf.Cron)
+	fields := strings.Fields(spec)
+	if jobConf.CronVersion >= CronVersion || len(fields) != 5 || strings.HasPrefix(spec, "@") {
+		return ParseCron(spec)
+	}
+	log.Warnf("the job: %s saved before the cron version %d, the legacy cron: %s is parsed as `second minute hour day-of-month month`, save the job with 6 fields to upgrade it", jobConf.Id, CronVersion, spec)
+	return ParseCron(strings.Join(append(fields, "*"), " "))
+}
+
+// parse the day of month field with the L, LW and W modifiers
+func (s *CronSchedule) parseDom(field string) error {
+	s.domStar = field == "*" || field == "?"
+	for _, item := range strings.Split(field, ",") {
+		upper := strings.ToUpper(item)
+		switch {
+		case upper == "L":
+			s.lastDay = true
+		case upper == "LW":
+			s.lastWeekday = true
+		case strings.HasSuffix(upper, "W"):
+			day, err := parseCronValue(item[:len(item)-1], cronDom)
+			if err != nil {
+				return err
+			}
+			s.nearestWeekdays = append(s.nearestWeekdays, day)
+		default:
+			bits, err := parseCronField(item, cronDom)
+			if err != nil {
+				return err
+			}
+			s.dom |= bits
+		}
+	}
+	return nil
+}
+
+// parse the day of week field with the L and # modifiers
+func (s *CronSchedule) parseDow(field string) error {
+	s.dowStar = field == "*" || field == "?"
+	for _, item := range strings.Split(field, ",") {
+		upper := strings.ToUpper(item)
+		switch {
+		case len(upper) > 1 && strings.HasSuffix(upper, "L"):
+			dow, err := parseCronValue(item[:len(item)-1], cronDow)
+			if err != nil {
+				return err
+			}
+			s.lastDows = append(s.lastDows, dow%7)
+		case strings.Contains(upper, "#"):
+			pos := strings.Index(item, "#")
+			dow, err := parseCronValue(item[:pos], cronDow)
+			if err != nil {
+				return err
+			}
+			nth, err := strconv.Atoi(item[pos+1:])
+			if err != nil || nth < 1 || nth > 5 {
+				return fmt.Errorf("the nth of %s must be between 1 and 5", item)
+			}
+			s.nthDows = append(s.nthDows, [2]int{dow % 7, nth})
+		default:
+			bits, err := parseCronField(item, cronDow)
+			if err != nil {
+				return err
+			}
+			// 7 is sunday as well as 0
+			if bits&(1<<7) > 0 {
+				bits |= 1
+			}
+			s.dow |= bits
+		}
+	}
+	return nil
+}
+
+// parse the comma separated ranges of the field to the bits
+func parseCronField(field string, b cronBounds) (bits uint64, err error) {
+	for _, expr := range strings.Split(field, ",") {
+		var bit uint64
+		if bit, err = parseCronRange(expr, b); err != nil {
+			return
+		}
+		bits |= bit
+	}
+	return
+}
+
+// parse the range of the field: *, ?, a, a-b, */n, a/n, a-b/n
+func parseCronRange(expr string, b cronBounds) (bits uint64, err error) {
+	var (
+		start, end int
+		step       = 1
+	)
+	rangeAndStep := strings.Split(expr, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, fmt.Errorf("too many slashes in the %s: %s", b.name, expr)
+	}
+	lowAndHigh := strings.Split(rangeAndStep[0], "-")
+	switch {
+	case lowAndHigh[0] == "*" || lowAndHigh[0] == "?":
+		if len(lowAndHigh) > 1 {
+			return 0, fmt.Errorf("invalid range in the %s: %s", b.name, expr)
+		}
+		start, end = b.min, b.max
+	case len(lowAndHigh) == 1:
+		if start, err = parseCronValue(lowAndHigh[0], b); err != nil {
+			return
+		}
+		end = start
+		if len(rangeAndStep) == 2 {
+			end = b.max
+		}
+	case len(lowAndHigh) == 2:
+		if start, err = parseCronValue(lowAndHigh[0], b); err != nil {
+			return
+		}
+		if end, err = parseCronValue(lowAndHigh[1], b); err != nil {
+			return
+		}
+	default:
+		return 0, fmt.Errorf("too many hyphens in the %s: %s", b.name, expr)
+	}
+	if len(rangeAndStep) == 2 {
+		if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+			return 0, fmt.Errorf("invalid step in the %s: %s", b.name, expr)
+		}
+	}
+	if start > end {
+		return 0, fmt.Errorf("the beginning of the range is beyond the end in the %s: %s", b.name, expr)
+	}
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return
+}
+
+// parse the number or the name of the field
+func parseCronValue(value string, b cronBounds) (int, error) {
+	if n, ok := b.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in the %s: %s", b.name, value)
+	}
+	if n < b.min || n > b.max {
+		return 0, fmt.Errorf("the value %d is out of the range [%d, %d] in the %s", n, b.min, b.max, b.name)
+	}
+	return n, nil
+}
+
+// Next the next schedule time after the time, the zero time if not found in 5 years
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+	added := false
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Second())&s.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.domMatches(t)
+	dowMatch := s.dowMatches(t)
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *CronSchedule) domMatches(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&s.dom > 0 {
+		return true
+	}
+	lastDay := daysIn(t)
+	if s.lastDay && day == lastDay {
+		return true
+	}
+	if s.lastWeekday && day == nearestWeekday(t, lastDay, lastDay) {
+		return true
+	}
+	for _, n := range s.nearestWeekdays {
+		if day == nearestWeekday(t, n, lastDay) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CronSchedule) dowMatches(t time.Time) bool {
+	dow := int(t.Weekday())
+	if 1<<uint(dow)&s.dow > 0 {
+		return true
+	}
+	day := t.Day()
+	for _, lastDow := range s.lastDows {
+		if dow == lastDow && day+7 > daysIn(t) {
+			return true
+		}
+	}
+	for _, nthDow := range s.nthDows {
+		if dow == nthDow[0] && (day-1)/7+1 == nthDow[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// the days in the month of the time
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// the weekday nearest the nth day in the month of the time, never cross the month
+func nearestWeekday(t time.Time, n int, lastDay int) int {
+	if n > lastDay {
+		n = lastDay
+	}
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if n == 1 {
+			return n + 2
+		}
+		return n - 1
+	case time.Sunday:
+		if n == lastDay {
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
+}
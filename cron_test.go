@@ -0,0 +1,69 @@
+package forest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC) // Thursday
+	cases := []struct {
+		spec     string
+		expected string
+	}{
+		{"*/15 * * * * *", "2026-01-01 00:00:15"},
+		{"30 8 * * *", "2026-01-01 08:30:00"},
+		{"0 30 8 * * MON-FRI", "2026-01-01 08:30:00"},
+		{"@hourly", "2026-01-01 01:00:00"},
+		{"@daily", "2026-01-02 00:00:00"},
+		{"0 0 L * *", "2026-01-31 00:00:00"},
+		{"0 0 L 2 *", "2026-02-28 00:00:00"},
+		{"0 0 LW 5 ?", "2026-05-29 00:00:00"},    // the 31st is Sunday
+		{"0 0 1W 8 ?", "2026-08-03 00:00:00"},    // the 1st is Saturday
+		{"0 0 15W 3 ?", "2026-03-16 00:00:00"},   // the 15th is Sunday
+		{"0 0 ? * 5L", "2026-01-30 00:00:00"},    // the last Friday
+		{"0 0 ? * FRI#3", "2026-01-16 00:00:00"}, // the third Friday
+		{"0 0 ? * 7", "2026-01-04 00:00:00"},     // 7 is Sunday
+	}
+	for _, c := range cases {
+		schedule, err := ParseCron(c.spec)
+		if err != nil {
+			t.Fatalf("parse %s error: %v", c.spec, err)
+		}
+		if next := ToDateString(schedule.Next(from)); next != c.expected {
+			t.Fatalf("the next of %s: %s, expected %s", c.spec, next, c.expected)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * * * *", "0 0 ? * 5#6", "@never", "5-1 * * * *", "@every 100ms"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Fatalf("the invalid expression: %q parsed", spec)
+		}
+	}
+
+	if next := (&CronSchedule{}).Next(from); !next.IsZero() {
+		t.Fatalf("the schedule never match should return the zero time: %v", next)
+	}
+}
+
+func TestParseJobCron(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		conf     *JobConf
+		expected string
+	}{
+		{&JobConf{Cron: "0 * * * *"}, "2026-01-01 00:01:00"}, // the legacy second 0 of every minute
+		{&JobConf{Cron: "0 * * * *", CronVersion: CronVersion}, "2026-01-01 01:00:00"},
+		{&JobConf{Cron: "30 0 8 * * *"}, "2026-01-01 08:00:30"},
+		{&JobConf{Cron: "@hourly"}, "2026-01-01 01:00:00"},
+	}
+	for _, c := range cases {
+		schedule, err := parseJobCron(c.conf)
+		if err != nil {
+			t.Fatalf("parse %s error: %v", c.conf.Cron, err)
+		}
+		if next := ToDateString(schedule.Next(from)); next != c.expected {
+			t.Fatalf("the next of %s version %d: %s, expected %s", c.conf.Cron, c.conf.CronVersion, next, c.expected)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/andistributed/etcd/etcdevent"
+	"github.com/webx-top/com"
+)
+
+// the delayed tasks and the retry attempts of the jobs run once at the fire time, the leader mark the task fired
+// before dispatch it and delete the task after the snapshot dispatched, so the task is never lost by the crash
+
+const (
+	DelayedTaskPath = "/forest/server/delayed/" // + task.id
+)
+
+// delayedRefireInterval the interval to fire the task again when failed to mark it fired
+const delayedRefireInterval = 10 * time.Second
+
+// onceSchedule the schedule of the delayed task which has only one schedule time
+type onceSchedule struct {
+	at time.Time
+}
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.at) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// the fire time of the delayed task
+func (task *DelayedTask) fireTime() (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", task.FireTime, time.Local)
+}
+
+// put the delayed task into the plan queue, the overdue task fire at once
+func (sch *JobScheduler) putDelayedPlan(task *DelayedTask) {
+	fireTime, err := task.fireTime()
+	if err != nil {
+		log.Errorf("the delayed task: %s parse the fire time: %s error: %v", task.Id, task.FireTime, err)
+		return
+	}
+	sch.deleteDelayedPlan(task.Id)
+	plan := &SchedulePlan{
+		Id:       task.Id,
+		Name:     task.Name,
+		Group:    task.Group,
+		Target:   task.Target,
+		Params:   task.Params,
+		Remark:   task.Remark,
+		Status:   JobRunningStatus,
+		schedule: onceSchedule{at: fireTime},
+		NextTime: fireTime,
+		delayed:  task,
+	}
+	sch.delayedPlans[task.Id] = plan
+	sch.planQueue.push(plan)
+	log.Infof("the delayed task: %s will fire at: %s", task.Id, task.FireTime)
+}
+
+// delete the delayed task from the plan queue
+func (sch *JobScheduler) deleteDelayedPlan(id string) {
+	plan, ok := sch.delayedPlans[id]
+	if !ok {
+		return
+	}
+	sch.planQueue.remove(plan)
+	delete(sch.delayedPlans, id)
+}
+
+// fire the due delayed task on the leader, the followers only drop it and
+// reload the tasks not deleted yet when they become the leader
+func (sch *JobScheduler) fireDelayedPlan(plan *SchedulePlan, now time.Time) *scheduleFire {
+	sch.deleteDelayedPlan(plan.Id)
+	if sch.node.state != NodeLeaderState {
+		return nil
+	}
+	log.Infof("schedule execute the delayed task: %#v", plan.delayed)
+	return &scheduleFire{snapshot: plan.newSnapshot(now), task: plan.delayed}
+}
+
+// dispatch the snapshot of the fired delayed task, the task marked fired by the crashed leader
+// is dispatched again only if its snapshot not found
+func (sch *JobScheduler) fireDelayedTask(task *DelayedTask, snapshot *JobSnapshot) {
+	if task.Fired {
+		if sch.dispatched(snapshot) {
+			log.Warnf("the fired delayed task: %s has been dispatched, delete it", task.Id)
+			sch.deleteFiredTask(task)
+			return
+		}
+	} else if ok, err := sch.markFired(task); err != nil {
+		log.Errorf("mark the delayed task: %s fired error: %v, fire it later", task.Id, err)
+		time.AfterFunc(delayedRefireInterval, func() {
+			sch.pushJobChangeEvent(&JobChangeEvent{Type: DelayedTaskPutChangeEvent, Task: task})
+		})
+		return
+	} else if !ok {
+		log.Warnf("the delayed task: %s is canceled before fired", task.Id)
+		return
+	}
+	if err := sch.node.exec.handleJobSnapshot(snapshot); err != nil && !isSnapshotParked(err) {
+		log.Errorf("dispatch the delayed task: %s error: %v", task.Id, err)
+		if !sch.dispatched(snapshot) {
+			sch.node.collection.recordJobSnapshot(snapshot, JobExecuteSnapshotErrorStatus, err.Error())
+		}
+	}
+	sch.deleteFiredTask(task)
+}
+
+// mark the delayed task fired, return false if the task has been canceled
+func (sch *JobScheduler) markFired(task *DelayedTask) (ok bool, err error) {
+	var value, v []byte
+	if value, err = sch.node.etcd.Get(DelayedTaskPath + task.Id); err != nil || len(value) == 0 {
+		return
+	}
+	fired := *task
+	fired.Fired = true
+	if v, err = PackDelayedTask(&fired); err != nil {
+		return
+	}
+	if ok, err = sch.node.etcd.Update(DelayedTaskPath+task.Id, string(v), string(value)); ok {
+		task.Fired = true
+	}
+	return
+}
+
+// check the snapshot is given to a client, parked or recorded
+func (sch *JobScheduler) dispatched(snapshot *JobSnapshot) bool {
+	for _, key := range []string{
+		JobWaitingPath + snapshot.Group + "/" + snapshot.Id,
+		JobPendingPath + snapshot.Group + "/" + snapshot.Id,
+	} {
+		if value, err := sch.node.etcd.Get(key); err == nil && len(value) > 0 {
+			return true
+		}
+	}
+	if keys, _, err := sch.node.etcd.GetWithPrefixKey(fmt.Sprintf(JobSnapshotGroupPath, snapshot.Group)); err == nil {
+		for _, key := range keys {
+			if strings.HasSuffix(string(key), "/"+snapshot.Id) {
+				return true
+			}
+		}
+	}
+	executeSnapshot, err := sch.node.collection.findExecuteSnapshot(snapshot.Id)
+	return err == nil && executeSnapshot != nil
+}
+
+// delete the fired delayed task
+func (sch *JobScheduler) deleteFiredTask(task *DelayedTask) {
+	if err := sch.node.etcd.Delete(DelayedTaskPath + task.Id); err != nil {
+		log.Errorf("delete the fired delayed task: %s error: %v", task.Id, err)
+	}
+}
+
+// load the delayed tasks not deleted yet, the fired ones are checked before dispatched again
+func (sch *JobScheduler) loadDelayedPlans() {
+	tasks, err := sch.node.manager.DelayedTaskList()
+	if err != nil {
+		log.Errorf("load the delayed tasks error: %v", err)
+		return
+	}
+	sch.lk.Lock()
+	defer sch.lk.Unlock()
+	for _, task := range tasks {
+		if _, ok := sch.delayedPlans[task.Id]; !ok {
+			sch.putDelayedPlan(task)
+		}
+	}
+}
+
+func (manager *JobManager) watchDelayedTaskPath() {
+	keyChangeEventResponse := manager.node.etcd.WatchWithPrefixKey(DelayedTaskPath)
+	for ch := range keyChangeEventResponse.Event {
+		manager.handleDelayedTaskChangeEvent(ch)
+	}
+}
+
+func (manager *JobManager) loopLoadDelayedTask() {
+	tasks, err := manager.DelayedTaskList()
+	if err != nil {
+		log.Errorf("load the delayed tasks error: %v", err)
+		return
+	}
+	for _, task := range tasks {
+		manager.node.scheduler.pushJobChangeEvent(&JobChangeEvent{
+			Type: DelayedTaskPutChangeEvent,
+			Task: task,
+		})
+	}
+}
+
+func (manager *JobManager) handleDelayedTaskChangeEvent(changeEvent *etcdevent.KeyChangeEvent) {
+	switch changeEvent.Type {
+	case etcdevent.KeyCreateChangeEvent, etcdevent.KeyUpdateChangeEvent:
+		if len(changeEvent.Value) == 0 {
+			return
+		}
+		task, err := UnpackDelayedTask(changeEvent.Value)
+		if err != nil {
+			log.Errorf("unpack the delayed task err: %#v", err)
+			return
+		}
+		if task.Fired {
+			// marked by the leader, the new leader load it again
+			return
+		}
+		manager.node.scheduler.pushJobChangeEvent(&JobChangeEvent{
+			Type: DelayedTaskPutChangeEvent,
+			Task: task,
+		})
+
+	case etcdevent.KeyDeleteChangeEvent:
+		manager.node.scheduler.pushJobChangeEvent(&JobChangeEvent{
+			Type: DelayedTaskDeleteChangeEvent,
+			Task: &DelayedTask{Id: strings.TrimPrefix(changeEvent.Key, DelayedTaskPath)},
+		})
+	}
+}
+
+// AddDelayedTask add a delayed task run once at the fire time or after the delay
+func (manager *JobManager) AddDelayedTask(task *DelayedTask) (err error) {
+	var (
+		value    []byte
+		v        []byte
+		success  bool
+		fireTime time.Time
+	)
+	if len(task.Group) == 0 {
+		err = errors.New("group不能为空")
+		return
+	}
+	if len(task.Target) == 0 {
+		err = errors.New("target不能为空")
+		return
+	}
+	if value, err = manager.node.etcd.Get(GroupConfPath + task.Group); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("任务集群不存在")
+		return
+	}
+	now := time.Now()
+	if len(task.FireTime) > 0 {
+		if fireTime, err = task.fireTime(); err != nil {
+			err = errors.New("非法的执行时间: " + task.FireTime)
+			return
+		}
+	} else if task.Delay > 0 {
+		fireTime = now.Add(time.Duration(task.Delay) * time.Second)
+	} else {
+		err = errors.New("执行时间和延迟时间不能同时为空")
+		return
+	}
+	if !fireTime.After(now) {
+		err = errors.New("执行时间必须晚于当前时间")
+		return
+	}
+	task.Id = GenerateSerialNo()
+	task.Name = com.Substr(task.Name, ``, 120)
+	task.Snapshot = nil
+	task.FireTime = ToDateString(fireTime)
+	task.Delay = 0
+	task.CreateTime = ToDateString(now)
+	if v, err = PackDelayedTask(task); err != nil {
+		return
+	}
+	if success, _, err = manager.node.etcd.PutNotExist(DelayedTaskPath+task.Id, string(v)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("创建失败,请重试！")
+	}
+	return
+}
+
+// add the retry attempt as a delayed task, so it fire at the time even the leader changed
+func (manager *JobManager) addRetryTask(retry *JobSnapshot, fireTime time.Time) (err error) {
+	var (
+		value   []byte
+		success bool
+	)
+	task := &DelayedTask{
+		Id:         retry.Id,
+		Name:       retry.Name,
+		Group:      retry.Group,
+		Target:     retry.Target,
+		Params:     retry.Params,
+		Remark:     retry.Remark,
+		FireTime:   ToDateString(fireTime),
+		CreateTime: ToDateString(time.Now()),
+		Snapshot:   retry,
+	}
+	if value, err = PackDelayedTask(task); err != nil {
+		return
+	}
+	if success, _, err = manager.node.etcd.PutNotExist(DelayedTaskPath+task.Id, string(value)); err != nil {
+		return
+	}
+	if !success {
+		err = fmt.Errorf("the retry task: %s exist", task.Id)
+	}
+	return
+}
+
+// CancelDelayedTask cancel the delayed task not fired yet
+func (manager *JobManager) CancelDelayedTask(id string) (err error) {
+	var value []byte
+	if len(id) == 0 {
+		err = errors.New("此延迟任务不存在")
+		return
+	}
+	if value, err = manager.node.etcd.Get(DelayedTaskPath + id); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此延迟任务不存在或已执行")
+		return
+	}
+	if task, err := UnpackDelayedTask(value); err == nil && task.Fired {
+		return errors.New("此延迟任务已执行")
+	}
+	err = manager.node.etcd.Delete(DelayedTaskPath + id)
+	return
+}
+
+// DelayedTaskList the delayed tasks not fired yet or fired but not dispatched yet
+func (manager *JobManager) DelayedTaskList() (tasks []*DelayedTask, err error) {
+	var values [][]byte
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(DelayedTaskPath); err != nil {
+		return
+	}
+	tasks = make([]*DelayedTask, 0, len(values))
+	for _, value := range values {
+		task, err := UnpackDelayedTask(value)
+		if err != nil {
+			log.Errorf("unpack the delayed task error: %#v", err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package forest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andistributed/etcd/etcdevent"
+)
+
+func TestDelayedTaskFire(t *testing.T) {
+	now := time.Now()
+	sch := newTestScheduler(0, now)
+	sch.delayedPlans = make(map[string]*SchedulePlan)
+	sch.eventChan = make(chan *JobChangeEvent, 1)
+	task := &DelayedTask{Id: "d1", Group: "g", Target: "t", FireTime: ToDateString(now.Add(-time.Second))}
+
+	sch.putDelayedPlan(task)
+	if _, fires := sch.schedule(now); len(fires) != 0 || len(sch.delayedPlans) != 0 {
+		t.Fatalf("the follower fired the delayed task: %v", fires)
+	}
+	sch.node.state = NodeLeaderState
+	sch.putDelayedPlan(task)
+	_, fires := sch.schedule(now)
+	if len(fires) != 1 || fires[0].task != task || fires[0].snapshot.Id != task.Id {
+		t.Fatalf("the leader not fire the delayed task: %v", fires)
+	}
+
+	// the task marked fired by the leader is not scheduled again by the watch
+	manager := &JobManager{node: &JobNode{scheduler: sch}}
+	fired := *task
+	fired.Fired = true
+	value, _ := PackDelayedTask(&fired)
+	manager.handleDelayedTaskChangeEvent(&etcdevent.KeyChangeEvent{Type: etcdevent.KeyUpdateChangeEvent, Key: DelayedTaskPath + task.Id, Value: value})
+	if len(sch.eventChan) != 0 {
+		t.Fatal("the fired delayed task put into the plan queue again")
+	}
+	value, _ = PackDelayedTask(task)
+	manager.handleDelayedTaskChangeEvent(&etcdevent.KeyChangeEvent{Type: etcdevent.KeyCreateChangeEvent, Key: DelayedTaskPath + task.Id, Value: value})
+	if event := <-sch.eventChan; event.Type != DelayedTaskPutChangeEvent || event.Task.Fired {
+		t.Fatalf("the delayed task event: %#v", event)
+	}
+}
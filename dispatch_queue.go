@@ -0,0 +1,191 @@
+package forest
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+)
+
+// the bounded dispatch queue of the snapshots, the snapshot of the higher priority dispatch first
+// and the snapshots of the same priority dispatch in order
+
+const (
+	DispatchOverflowBlock = "block" // block the push until the queue has space, the scheduler spill into the waiting queue instead
+	DispatchOverflowDrop  = "drop"  // drop the snapshot of the lowest priority
+)
+
+var (
+	// DispatchQueueSize the capacity of the dispatch queue
+	DispatchQueueSize = 500
+	// DispatchWorkers the workers dispatch the snapshots in the queue
+	DispatchWorkers = 4
+	// DispatchOverflow the behavior when the dispatch queue is full: block, drop
+	DispatchOverflow = DispatchOverflowBlock
+)
+
+// errDispatchQueueFull the dispatch queue is full
+var errDispatchQueueFull = errors.New("the dispatch queue is full")
+
+type dispatchItem struct {
+	snapshot *JobSnapshot
+	seq      uint64
+}
+
+type dispatchHeap []*dispatchItem
+
+func (h dispatchHeap) Len() int { return len(h) }
+
+func (h dispatchHeap) Less(i, j int) bool {
+	if h[i].snapshot.Priority != h[j].snapshot.Priority {
+		return h[i].snapshot.Priority > h[j].snapshot.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h dispatchHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *dispatchHeap) Push(x interface{}) {
+	*h = append(*h, x.(*dispatchItem))
+}
+
+func (h *dispatchHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// DispatchQueueStats the stats of the dispatch queue
+type DispatchQueueStats struct {
+	Size     int         `json:"size"`
+	Capacity int         `json:"capacity"`
+	Workers  int         `json:"workers"`
+	Overflow string      `json:"overflow"`
+	Depth    map[int]int `json:"depth"` // the count of the snapshots waiting in the queue by the priority
+	Dropped  uint64      `json:"dropped"`
+}
+
+type dispatchQueue struct {
+	items    dispatchHeap
+	capacity int
+	overflow string
+	seq      uint64
+	depth    map[int]int
+	dropped  uint64
+	lk       *sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+}
+
+func newDispatchQueue(capacity int, overflow string) *dispatchQueue {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	q := &dispatchQueue{
+		items:    make(dispatchHeap, 0, capacity),
+		capacity: capacity,
+		overflow: overflow,
+		depth:    make(map[int]int),
+		lk:       &sync.Mutex{},
+	}
+	q.notEmpty = sync.NewCond(q.lk)
+	q.notFull = sync.NewCond(q.lk)
+	return q
+}
+
+// push the snapshot into the queue, return the dropped snapshot when the queue is full
+// and the overflow is drop, the dropped one may be the pushed one if it has the lowest priority
+func (q *dispatchQueue) push(snapshot *JobSnapshot) (dropped *JobSnapshot) {
+	_, dropped = q.put(snapshot, true)
+	return
+}
+
+// offer the snapshot without blocking, return false when the queue is full and the overflow is block
+func (q *dispatchQueue) offer(snapshot *JobSnapshot) (ok bool, dropped *JobSnapshot) {
+	return q.put(snapshot, false)
+}
+
+func (q *dispatchQueue) put(snapshot *JobSnapshot, block bool) (ok bool, dropped *JobSnapshot) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	for len(q.items) >= q.capacity && q.overflow != DispatchOverflowDrop {
+		if !block {
+			return false, nil
+		}
+		q.notFull.Wait()
+	}
+	if len(q.items) >= q.capacity {
+		lowest := q.lowest()
+		q.dropped++
+		if snapshot.Priority <= q.items[lowest].snapshot.Priority {
+			return true, snapshot
+		}
+		dropped = heap.Remove(&q.items, lowest).(*dispatchItem).snapshot
+		q.decr(dropped.Priority)
+	}
+	q.seq++
+	heap.Push(&q.items, &dispatchItem{snapshot: snapshot, seq: q.seq})
+	q.depth[snapshot.Priority]++
+	q.notEmpty.Signal()
+	return true, dropped
+}
+
+// pop the snapshot of the highest priority, block until the queue is not empty
+func (q *dispatchQueue) pop() *JobSnapshot {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	for len(q.items) == 0 {
+		q.notEmpty.Wait()
+	}
+	snapshot := heap.Pop(&q.items).(*dispatchItem).snapshot
+	q.decr(snapshot.Priority)
+	q.notFull.Signal()
+	return snapshot
+}
+
+// the index of the item of the lowest priority pushed last
+func (q *dispatchQueue) lowest() int {
+	index := 0
+	for i := range q.items {
+		if !q.items.Less(i, index) {
+			index = i
+		}
+	}
+	return index
+}
+
+// check the snapshot is waiting in the queue
+func (q *dispatchQueue) contains(id string) bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	for _, item := range q.items {
+		if item.snapshot.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *dispatchQueue) decr(priority int) {
+	if q.depth[priority]--; q.depth[priority] <= 0 {
+		delete(q.depth, priority)
+	}
+}
+
+func (q *dispatchQueue) stats() *DispatchQueueStats {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	depth := make(map[int]int, len(q.depth))
+	for priority, count := range q.depth {
+		depth[priority] = count
+	}
+	return &DispatchQueueStats{
+		Size:     len(q.items),
+		Capacity: q.capacity,
+		Overflow: q.overflow,
+		Depth:    depth,
+		Dropped:  q.dropped,
+	}
+}
@@ -0,0 +1,51 @@
+package forest
+
+import "testing"
+
+func TestDispatchQueuePriority(t *testing.T) {
+	q := newDispatchQueue(3, DispatchOverflowDrop)
+	q.push(&JobSnapshot{Id: "a", Priority: 0})
+	q.push(&JobSnapshot{Id: "b", Priority: 5})
+	q.push(&JobSnapshot{Id: "c", Priority: 0})
+	if dropped := q.push(&JobSnapshot{Id: "d", Priority: 0}); dropped == nil || dropped.Id != "d" {
+		t.Fatalf("the pushed snapshot of the lowest priority not dropped: %v", dropped)
+	}
+	if dropped := q.push(&JobSnapshot{Id: "e", Priority: 1}); dropped == nil || dropped.Id != "c" {
+		t.Fatalf("the last snapshot of the lowest priority not dropped: %v", dropped)
+	}
+	stats := q.stats()
+	if stats.Size != 3 || stats.Dropped != 2 || stats.Depth[0] != 1 || stats.Depth[1] != 1 || stats.Depth[5] != 1 {
+		t.Fatalf("the stats: %#v", stats)
+	}
+	if !q.contains("e") || q.contains("c") {
+		t.Fatal("the queued snapshots not found or the dropped one found")
+	}
+	var ids string
+	for i := 0; i < 3; i++ {
+		ids += q.pop().Id
+	}
+	if ids != "bea" {
+		t.Fatalf("the dispatch order: %s", ids)
+	}
+	if q.contains("b") {
+		t.Fatal("the dispatched snapshot still found in the queue")
+	}
+	if len(q.stats().Depth) != 0 {
+		t.Fatalf("the depth not cleared: %v", q.stats().Depth)
+	}
+}
+
+func TestDispatchQueueOffer(t *testing.T) {
+	q := newDispatchQueue(1, DispatchOverflowBlock)
+	if ok, dropped := q.offer(&JobSnapshot{Id: "a"}); !ok || dropped != nil {
+		t.Fatalf("offer to the empty queue: %v, %v", ok, dropped)
+	}
+	if ok, _ := q.offer(&JobSnapshot{Id: "b", Priority: 9}); ok {
+		t.Fatal("offer to the full queue should not block or succeed")
+	}
+	q = newDispatchQueue(1, DispatchOverflowDrop)
+	q.offer(&JobSnapshot{Id: "a"})
+	if ok, dropped := q.offer(&JobSnapshot{Id: "b", Priority: 9}); !ok || dropped == nil || dropped.Id != "a" {
+		t.Fatalf("offer to the full queue should drop the lowest: %v, %v", ok, dropped)
+	}
+}
@@ -1,7 +1,9 @@
 package forest
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/admpub/log"
 )
@@ -13,39 +15,91 @@ const (
 )
 
 type JobExecutor struct {
-	node      *JobNode
-	snapshots chan *JobSnapshot
+	node    *JobNode
+	queue   *dispatchQueue
+	workers int
 }
 
 func NewJobExecutor(node *JobNode) (exec *JobExecutor) {
+	overflow := DispatchOverflow
+	if overflow != DispatchOverflowBlock && overflow != DispatchOverflowDrop {
+		log.Warnf("unknown dispatch overflow: %s, use the block overflow", overflow)
+		overflow = DispatchOverflowBlock
+	}
 	exec = &JobExecutor{
-		node:      node,
-		snapshots: make(chan *JobSnapshot, 500),
+		node:    node,
+		queue:   newDispatchQueue(DispatchQueueSize, overflow),
+		workers: DispatchWorkers,
+	}
+	if exec.workers <= 0 {
+		exec.workers = 1
+	}
+	for i := 0; i < exec.workers; i++ {
+		go exec.lookup()
 	}
-	go exec.lookup()
 	return
 }
 
 func (exec *JobExecutor) lookup() {
-	for snapshot := range exec.snapshots {
+	for {
+		snapshot := exec.queue.pop()
 		err := exec.handleJobSnapshot(snapshot)
-		if err != nil {
+		if err != nil && !isSnapshotParked(err) {
 			log.Error(err)
 		}
 	}
 }
 
 // handle the job snapshot
-func (exec *JobExecutor) handleJobSnapshot(snapshot *JobSnapshot) error {
+func (exec *JobExecutor) handleJobSnapshot(snapshot *JobSnapshot) (err error) {
 	var (
-		err    error
 		client *Client
+		conf   *JobConf
 	)
 	group := snapshot.Group
-	if client, err = exec.node.groupManager.selectClient(group); err != nil {
+	conf = exec.loadJobConf(snapshot)
+	if conf != nil {
+		if err = exec.checkConcurrency(snapshot, conf); err != nil {
+			return err
+		}
+		if snapshot.Timeout == 0 {
+			snapshot.Timeout = conf.Timeout
+		}
+	}
+	if !snapshot.isShard() {
+		if err = exec.node.limiter.acquire(snapshot, conf); err != nil {
+			return exec.node.limiter.wait(snapshot, err)
+		}
+		defer func() {
+			if err != nil {
+				// not given to a client, free the slot
+				exec.node.limiter.done(snapshot.Id)
+			}
+		}()
+	}
+	if conf != nil && len(conf.Mode) > 0 && !snapshot.isShard() {
+		return exec.handleJobShards(snapshot, conf)
+	}
+	if client, err = exec.node.groupManager.selectClient(group, snapshot, conf); err != nil {
+		if errors.Is(err, ErrNoClientMatched) {
+			exec.node.collection.recordJobSnapshot(snapshot, JobExecuteSnapshotErrorStatus, "没有匹配标签选择器的客户端: "+conf.LabelSelector)
+		}
+		if errors.Is(err, ErrNoFreeSlot) {
+			// not dispatched, queue it until a slot is free
+			return exec.node.limiter.wait(snapshot, err)
+		}
+		if errors.Is(err, ErrNoClient) {
+			// not dispatched, pending until a client registered
+			return exec.node.pending.put(snapshot)
+		}
 		return fmt.Errorf("the group: %s, select a client error: %w", group, err)
 	}
+	return exec.dispatch(snapshot, client)
+}
 
+// dispatch the snapshot to the client
+func (exec *JobExecutor) dispatch(snapshot *JobSnapshot, client *Client) error {
+	group := snapshot.Group
 	clientName := client.name
 	snapshot.Ip = clientName
 
@@ -57,13 +111,140 @@ func (exec *JobExecutor) handleJobSnapshot(snapshot *JobSnapshot) error {
 	if err != nil {
 		return fmt.Errorf("pack the snapshot %s error: %w", group, err)
 	}
+	if err = exec.node.collection.recordDispatched(snapshot); err != nil {
+		exec.node.groupManager.releaseSlot(group, clientName, snapshot.Id)
+		return fmt.Errorf("record the snapshot %s error: %w", snapshot.Id, err)
+	}
 	if err = exec.node.etcd.Put(snapshotPath+snapshot.Id, string(value)); err != nil {
+		exec.node.groupManager.releaseSlot(group, clientName, snapshot.Id)
+		exec.node.collection.removeDispatched(snapshot.Id)
 		return fmt.Errorf("put the snapshot %s error: %w", group, err)
 	}
+	if !snapshot.isShard() {
+		exec.node.limiter.track(snapshot.Id, group, snapshot.JobId, clientName)
+	}
+	exec.node.timeout.track(snapshot, time.Now())
+	return nil
+}
+
+// load the job conf of the snapshot, the temporary snapshot has no job conf
+func (exec *JobExecutor) loadJobConf(snapshot *JobSnapshot) *JobConf {
+	if len(snapshot.JobId) == 0 {
+		return nil
+	}
+	conf, err := exec.node.manager.GetJob(snapshot.JobId)
+	if err != nil {
+		log.Warnf("the snapshot: %s load the job conf: %s error: %v", snapshot.Id, snapshot.JobId, err)
+		return nil
+	}
+	return conf
+}
+
+// check the concurrency policy of the job before dispatch the snapshot by the in-flight executions
+// tracked on the leader, the followers leave the snapshot to the leader
+func (exec *JobExecutor) checkConcurrency(snapshot *JobSnapshot, conf *JobConf) error {
+	if len(conf.Concurrency) == 0 || conf.Concurrency == ConcurrencyAllow {
+		return nil
+	}
+	if exec.node.state != NodeLeaderState {
+		return exec.node.limiter.wait(snapshot, errLimitOnLeader)
+	}
+	running := exec.node.limiter.jobSnapshots(snapshot.JobId, snapshot.Id)
+	skip, replaced := concurrencyAction(conf.Concurrency, running)
+	if skip {
+		reason := fmt.Sprintf("上一次执行(%s)尚未结束,跳过本次执行", running[0].id)
+		exec.node.collection.recordJobSnapshot(snapshot, JobExecuteSnapshotSkippedStatus, reason)
+		return fmt.Errorf("the job: %s, skip the snapshot: %s because the snapshot: %s is doing", snapshot.JobId, snapshot.Id, running[0].id)
+	}
+	for _, entry := range replaced {
+		log.Warnf("the job: %s, kill the in-flight snapshot: %s to replace with the snapshot: %s", snapshot.JobId, entry.id, snapshot.Id)
+		exec.replace(entry, snapshot)
+	}
 	return nil
 }
 
-// push a new job snapshot
+// the action of the concurrency policy on the in-flight executions of the job,
+// skip the snapshot or replace the in-flight executions
+func concurrencyAction(policy string, running []*limitEntry) (skip bool, replaced []*limitEntry) {
+	if len(running) == 0 {
+		return
+	}
+	switch policy {
+	case ConcurrencyForbid:
+		skip = true
+	case ConcurrencyReplace:
+		replaced = running
+	}
+	return
+}
+
+// replace the in-flight execution, the parent of the shards replace the doing shards recorded when dispatched
+func (exec *JobExecutor) replace(entry *limitEntry, snapshot *JobSnapshot) {
+	if len(entry.ip) > 0 {
+		exec.takeBack(entry.id, entry.group, entry.ip, snapshot)
+		return
+	}
+	shards, err := exec.node.collection.doingShards(entry.id)
+	if err != nil {
+		log.Warnf("load the shards of the snapshot: %s error: %v", entry.id, err)
+		return
+	}
+	for _, shard := range shards {
+		exec.takeBack(shard.Id, shard.Group, shard.Ip, snapshot)
+	}
+}
+
+// take back the snapshot not started yet from the client, otherwise kill the execution
+func (exec *JobExecutor) takeBack(id string, group string, ip string, snapshot *JobSnapshot) {
+	key := fmt.Sprintf(JobClientSnapshotPath, group, ip) + id
+	if value, err := exec.node.etcd.Get(key); err == nil && len(value) > 0 {
+		if err = exec.node.etcd.Delete(key); err != nil {
+			log.Warnf("take back the snapshot: %s error: %v", id, err)
+		} else if replaced, err := UnpackJobSnapshot(value); err == nil {
+			exec.node.collection.recordJobSnapshot(replaced, JobExecuteSnapshotSkippedStatus, fmt.Sprintf("被新的执行(%s)替换", snapshot.Id))
+			return
+		}
+	}
+	exec.kill(&JobSnapshot{Id: id, Group: group, Ip: ip})
+}
+
+// kill the execution of the snapshot
+func (exec *JobExecutor) kill(snapshot *JobSnapshot) {
+	if err := exec.node.manager.Kill(snapshot); err != nil {
+		log.Warnf("kill the snapshot: %s error: %v", snapshot.Id, err)
+	}
+}
+
+// push a new job snapshot into the dispatch queue, block until the queue has space unless the overflow is drop
 func (exec *JobExecutor) pushSnapshot(snapshot *JobSnapshot) {
-	exec.snapshots <- snapshot
+	exec.handleDropped(exec.queue.push(snapshot))
+}
+
+// push the snapshot fired by the scheduler into the dispatch queue, never block the scheduler
+// but spill the snapshot into the waiting queue when the queue is full
+func (exec *JobExecutor) scheduleSnapshot(snapshot *JobSnapshot) {
+	ok, dropped := exec.queue.offer(snapshot)
+	if !ok {
+		if err := exec.node.limiter.wait(snapshot, errDispatchQueueFull); !errors.Is(err, errSnapshotWaiting) {
+			log.Errorf("spill the snapshot: %s into the waiting queue error: %v", snapshot.Id, err)
+		}
+		return
+	}
+	exec.handleDropped(dropped)
+}
+
+// record the snapshot dropped by the dispatch queue
+func (exec *JobExecutor) handleDropped(dropped *JobSnapshot) {
+	if dropped == nil {
+		return
+	}
+	log.Warnf("the dispatch queue is full, drop the snapshot: %s of the job: %s priority: %d", dropped.Id, dropped.JobId, dropped.Priority)
+	exec.node.collection.recordJobSnapshot(dropped, JobExecuteSnapshotDroppedStatus, fmt.Sprintf("调度队列已满(%d),丢弃优先级为%d的执行", exec.queue.capacity, dropped.Priority))
+}
+
+// the stats of the dispatch queue
+func (exec *JobExecutor) queueStats() *DispatchQueueStats {
+	stats := exec.queue.stats()
+	stats.Workers = exec.workers
+	return stats
 }
@@ -1,6 +1,7 @@
 package forest
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -36,6 +37,12 @@ func (f *JobSnapshotFailOver) loop() {
 
 // handle job client delete event
 func (f *JobSnapshotFailOver) handleJobClientDeleteEvent(event *JobClientDeleteEvent) error {
+	return f.transfer(event.Group, event.Client.name, time.Time{})
+}
+
+// transfer the snapshots not started yet of the client to the other clients of the group,
+// retry loading the snapshots until the deadline, the zero deadline means retry forever
+func (f *JobSnapshotFailOver) transfer(group *Group, clientName string, deadline time.Time) error {
 
 	var (
 		keys    [][]byte
@@ -46,36 +53,87 @@ func (f *JobSnapshotFailOver) handleJobClientDeleteEvent(event *JobClientDeleteE
 	)
 
 RETRY:
-	prefixKey := fmt.Sprintf(JobClientSnapshotPath, event.Group.name, event.Client.name)
+	prefixKey := fmt.Sprintf(JobClientSnapshotPath, group.name, clientName)
 	if keys, values, err = f.node.etcd.GetWithPrefixKeyLimit(prefixKey, 1000); err != nil {
-		log.Errorf("the fail client: %v for path: %s, error must retry", event.Client.name, prefixKey)
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("the client: %v for path: %s, load the snapshots error: %w", clientName, prefixKey, err)
+		}
+		log.Errorf("the client: %v for path: %s, error must retry", clientName, prefixKey)
 		time.Sleep(time.Second * 2)
 		goto RETRY
 	}
 
 	if len(keys) == 0 || len(values) == 0 {
-		log.Warnf("the fail client: %v for path: %s is empty", event.Client.name, prefixKey)
+		log.Warnf("the client: %v for path: %s is empty", clientName, prefixKey)
 		return err
 	}
 
 	for index, key := range keys {
-		if client, err = event.Group.selectClient(); err != nil {
-			log.Error(err)
-			return err
-		}
 		from := string(key)
 		value := string(values[index])
+		snapshot, err := UnpackJobSnapshot(values[index])
+		if err != nil {
+			// the malformed snapshot can never be executed, drop it
+			log.Errorf("the client: %v, unpack the snapshot: %s error: %v, delete it", clientName, from, err)
+			if err = f.node.etcd.Delete(from); err != nil {
+				log.Error(err)
+				return err
+			}
+			continue
+		}
+		conf := f.node.exec.loadJobConf(snapshot)
+		if client, err = group.selectClient(snapshot, conf); err != nil {
+			log.Error(err)
+			if errors.Is(err, ErrNoFreeSlot) {
+				// queue the snapshot on the leader until a slot is free
+				f.node.limiter.done(snapshot.Id)
+				if err = f.node.limiter.wait(snapshot, err); !errors.Is(err, errSnapshotWaiting) {
+					return err
+				}
+				if err = f.node.etcd.Delete(from); err != nil {
+					log.Error(err)
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, ErrNoClient) {
+				// no other client of the group, pending until a client registered
+				f.node.limiter.done(snapshot.Id)
+				if err = f.node.pending.put(snapshot); !errors.Is(err, errSnapshotPending) {
+					return err
+				}
+				if err = f.node.etcd.Delete(from); err != nil {
+					log.Error(err)
+					return err
+				}
+				continue
+			}
+			if !errors.Is(err, ErrNoClientMatched) {
+				return err
+			}
+			// no other client matches the labels of the job, give up the snapshot
+			f.node.collection.recordJobSnapshot(snapshot, JobExecuteSnapshotErrorStatus, "转移执行快照时没有匹配标签选择器的客户端: "+conf.LabelSelector)
+			if err = f.node.etcd.Delete(from); err != nil {
+				log.Error(err)
+				return err
+			}
+			continue
+		}
 
 		// 新地址
-		to := fmt.Sprintf(JobClientSnapshotPath, event.Group.name, client.name) + strings.TrimPrefix(from, prefixKey)
+		to := fmt.Sprintf(JobClientSnapshotPath, group.name, client.name) + strings.TrimPrefix(from, prefixKey)
 
 		//  transfer the kv
 		success, err = f.node.etcd.Transfer(from, to, value)
 		if !success {
+			group.releaseSlot(client.name, snapshot.Id)
 			err = fmt.Errorf("transfer from %s to %s failed: %v", from, to, err)
 			log.Error(err)
 			return err
 		}
+		if !snapshot.isShard() {
+			f.node.limiter.track(snapshot.Id, snapshot.Group, snapshot.JobId, client.name)
+		}
 		log.Infof("successfully transferred from %s to %s", from, to)
 	}
 	goto RETRY
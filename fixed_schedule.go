@@ -0,0 +1,131 @@
+package forest
+
+import (
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/robfig/cron"
+)
+
+// fixedRateSchedule run at every interval aligned to the unix epoch,
+// so all the nodes agree on the schedule times across the leader changes
+type fixedRateSchedule struct {
+	interval time.Duration
+}
+
+func (s fixedRateSchedule) Next(t time.Time) time.Time {
+	elapsed := time.Duration(t.UnixNano())
+	return time.Unix(0, int64(elapsed-elapsed%s.interval+s.interval)).In(t.Location())
+}
+
+// fixedDelaySchedule run the interval after the previous execution finished,
+// the scheduler wait for the finish before using the next time again
+type fixedDelaySchedule struct {
+	interval time.Duration
+}
+
+func (s fixedDelaySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// build the schedule by the schedule type of the job conf
+func newSchedule(jobConf *JobConf) (cron.Schedule, error) {
+	interval := time.Duration(jobConf.Interval) * time.Second
+	switch jobConf.ScheduleType {
+	case ScheduleFixedRate:
+		return fixedRateSchedule{interval: interval}, nil
+	case ScheduleFixedDelay:
+		return fixedDelaySchedule{interval: interval}, nil
+	default:
+		return parseJobCron(jobConf)
+	}
+}
+
+// the fixed-delay plan wait for the execution finished after fired, the watchdog
+// resume the plan after the interval plus the timeout if the finish is lost
+func (sch *JobScheduler) waitPlan(plan *SchedulePlan, now time.Time) {
+	plan.NextTime = time.Time{}
+	plan.Waiting = true
+	plan.waitDeadline = now.Add(time.Duration(plan.Interval+plan.timeout) * time.Second)
+	sch.planQueue.remove(plan)
+}
+
+// schedule the waiting fixed-delay plan the interval after the execution finished
+func (sch *JobScheduler) handleJobExecuteFinishedEvent(event *JobChangeEvent) {
+	plan, ok := sch.schedulePlans[event.Conf.Id]
+	if !ok || plan.ScheduleType != ScheduleFixedDelay || !plan.Waiting {
+		return
+	}
+	sch.resumePlan(plan, time.Now())
+}
+
+// schedule the waiting fixed-delay plan the interval after now
+func (sch *JobScheduler) resumePlan(plan *SchedulePlan, now time.Time) {
+	plan.Waiting = false
+	plan.waitDeadline = time.Time{}
+	if plan.NextTime = sch.jitter(plan, plan.next(now)); plan.NextTime.IsZero() {
+		sch.finishPlan(plan)
+		return
+	}
+	sch.planQueue.push(plan)
+}
+
+// check the waiting fixed-delay plans on the leader, the execution lost by the crash of the client
+// never finish, resume the plan when the deadline passed, the plan without the timeout is resumed
+// only if the job has no in-flight execution
+func (sch *JobScheduler) checkWaitingPlans(now time.Time) {
+	if sch.node.state != NodeLeaderState {
+		return
+	}
+	sch.lk.Lock()
+	defer sch.lk.Unlock()
+	for id, plan := range sch.schedulePlans {
+		if !plan.lost(now) {
+			continue
+		}
+		if plan.timeout <= 0 && sch.node.limiter.inflight(id) > 0 {
+			plan.waitDeadline = now.Add(time.Duration(plan.Interval) * time.Second)
+			continue
+		}
+		log.Warnf("the fixed-delay plan: %s not receive the finish of the execution until %v, resume it", id, plan.waitDeadline)
+		sch.resumePlan(plan, now)
+	}
+}
+
+// check the waiting fixed-delay plan has passed the deadline
+func (plan *SchedulePlan) lost(now time.Time) bool {
+	return plan.ScheduleType == ScheduleFixedDelay && plan.Waiting && !plan.waitDeadline.IsZero() && now.After(plan.waitDeadline)
+}
+
+// resume the waiting fixed-delay plans when the node become the leader,
+// the followers never observe the finish of the executions
+func (sch *JobScheduler) resumeFixedDelayPlans() {
+	now := time.Now()
+	sch.lk.Lock()
+	defer sch.lk.Unlock()
+	for id, plan := range sch.schedulePlans {
+		if plan.ScheduleType != ScheduleFixedDelay || !plan.Waiting {
+			continue
+		}
+		running, err := sch.node.collection.runningSnapshots(id)
+		if err != nil {
+			log.Warnf("the plan: %s load the running snapshots error: %v", id, err)
+			continue
+		}
+		if len(running) > 0 {
+			continue
+		}
+		plan.Waiting, plan.waitDeadline = false, time.Time{}
+		if plan.NextTime = plan.next(now); plan.NextTime.IsZero() {
+			sch.finishPlan(plan)
+			continue
+		}
+		plan.NextTime = now
+		if finishTime, err := sch.node.collection.lastFinishTime(id); err == nil && !finishTime.IsZero() {
+			if nextTime := sch.jitter(plan, plan.next(finishTime)); nextTime.After(now) {
+				plan.NextTime = nextTime
+			}
+		}
+		sch.planQueue.push(plan)
+	}
+}
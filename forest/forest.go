@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/admpub/log"
 	"github.com/admpub/securecookie"
@@ -59,6 +60,13 @@ func main() {
 	flag.StringVar(&defaultAPISecret, "api-secret", defaultAPISecret, "--api-secret 01234567890123456789012345678901 (也可以通过环境变量FOREST_API_SECRET来指定)")
 
 	flag.DurationVar(&forest.ExecuteSnapshotCanRetry, "api-can-retry", forest.ExecuteSnapshotCanRetry, "--api-can-retry 6h") // 指定开始多长时间后可以重试，默认6h
+	flag.DurationVar(&forest.RetryBackoffLimit, "retry-backoff-limit", forest.RetryBackoffLimit, "--retry-backoff-limit 1h") // 自动重试的最大间隔，默认1h
+	flag.IntVar(&forest.DispatchQueueSize, "dispatch-queue-size", forest.DispatchQueueSize, "--dispatch-queue-size 500")     // 调度队列的容量，默认500
+	flag.IntVar(&forest.DispatchWorkers, "dispatch-workers", forest.DispatchWorkers, "--dispatch-workers 4")                 // 派发任务的协程数，默认4
+	flag.DurationVar(&forest.PendingMaxWait, "pending-max-wait", forest.PendingMaxWait, "--pending-max-wait 1h")             // 任务集群没有客户端时等待的最长时间，默认1h
+
+	// 调度队列已满时的处理方式，默认block
+	flag.StringVar(&forest.DispatchOverflow, "dispatch-overflow", forest.DispatchOverflow, "--dispatch-overflow block (block: 派发阻塞等待队列有空位,调度器不阻塞而是把执行快照转入等待队列; drop: 丢弃优先级最低的执行)")
 
 	// - admin
 	admName := flag.String("admin-name", "admin", "--admin-name admin (也可以通过环境变量FOREST_ADMIN_NAME来指定)")
@@ -3,7 +3,8 @@ package forest
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -65,19 +66,32 @@ RETRY:
 			log.Warnf("unpack the group conf error: %#v", err)
 			continue
 		}
-		mgr.addGroup(groupConf.Name, path)
+		mgr.addGroup(groupConf, path)
 	}
 }
 
-func (mgr *JobGroupManager) addGroup(name, path string) {
+func (mgr *JobGroupManager) addGroup(groupConf *GroupConf, path string) {
 	mgr.lk.Lock()
 	defer mgr.lk.Unlock()
 	if _, ok := mgr.groups[path]; ok {
 		return
 	}
-	group := NewGroup(name, path, mgr.node)
+	group := NewGroup(groupConf, path, mgr.node)
 	mgr.groups[path] = group
-	log.Infof("add a new group: %s, for path: %s", name, path)
+	log.Infof("add a new group: %s, for path: %s", groupConf.Name, path)
+}
+
+// update the group conf for path
+func (mgr *JobGroupManager) updateGroup(groupConf *GroupConf, path string) {
+	mgr.lk.RLock()
+	group, ok := mgr.groups[path]
+	mgr.lk.RUnlock()
+	if !ok {
+		mgr.addGroup(groupConf, path)
+		return
+	}
+	group.setConf(groupConf)
+	log.Infof("update the group: %s, for path: %s", groupConf.Name, path)
 }
 
 // delete a group for path
@@ -98,6 +112,18 @@ func (mgr *JobGroupManager) deleteGroup(path string) {
 	if group.cancelFunc != nil {
 		group.cancelFunc()
 	}
+	if group.snapshotWatcher != nil {
+		group.snapshotWatcher.Close()
+	}
+	if group.snapshotCancelFunc != nil {
+		group.snapshotCancelFunc()
+	}
+	if group.cordonWatcher != nil {
+		group.cordonWatcher.Close()
+	}
+	if group.cordonCancelFunc != nil {
+		group.cordonCancelFunc()
+	}
 	delete(mgr.groups, path)
 	log.Infof("delete a group: %s, for path: %s", group.name, path)
 }
@@ -109,7 +135,7 @@ func (mgr *JobGroupManager) handleGroupChangeEvent(changeEvent *etcdevent.KeyCha
 		mgr.handleGroupCreateEvent(changeEvent)
 
 	case etcdevent.KeyUpdateChangeEvent:
-		// ignore
+		mgr.handleGroupUpdateEvent(changeEvent)
 
 	case etcdevent.KeyDeleteChangeEvent:
 		mgr.handleGroupDeleteEvent(changeEvent)
@@ -123,7 +149,17 @@ func (mgr *JobGroupManager) handleGroupCreateEvent(changeEvent *etcdevent.KeyCha
 		return
 	}
 	path := changeEvent.Key
-	mgr.addGroup(groupConf.Name, path)
+	mgr.addGroup(groupConf, path)
+}
+
+func (mgr *JobGroupManager) handleGroupUpdateEvent(changeEvent *etcdevent.KeyChangeEvent) {
+	groupConf, err := UnpackGroupConf(changeEvent.Value)
+	if err != nil {
+		log.Warnf("unpack the group conf error: %#v", err)
+		return
+	}
+	path := changeEvent.Key
+	mgr.updateGroup(groupConf, path)
 }
 
 func (mgr *JobGroupManager) handleGroupDeleteEvent(changeEvent *etcdevent.KeyChangeEvent) {
@@ -131,44 +167,127 @@ func (mgr *JobGroupManager) handleGroupDeleteEvent(changeEvent *etcdevent.KeyCha
 	mgr.deleteGroup(path)
 }
 
-func (mgr *JobGroupManager) selectClient(name string) (client *Client, err error) {
+func (mgr *JobGroupManager) selectClient(name string, snapshot *JobSnapshot, conf *JobConf) (client *Client, err error) {
 	var (
 		group *Group
 		ok    bool
 	)
-	if group, ok = mgr.groups[GroupConfPath+name]; !ok {
+	mgr.lk.RLock()
+	group, ok = mgr.groups[GroupConfPath+name]
+	mgr.lk.RUnlock()
+	if !ok {
 		err = fmt.Errorf("the group: %s not found", name)
 		return
 	}
-	return group.selectClient()
+	return group.selectClient(snapshot, conf)
+}
+
+// the conf of the group, nil if not found
+func (mgr *JobGroupManager) groupConf(name string) *GroupConf {
+	mgr.lk.RLock()
+	group, ok := mgr.groups[GroupConfPath+name]
+	mgr.lk.RUnlock()
+	if !ok {
+		return nil
+	}
+	group.lk.RLock()
+	defer group.lk.RUnlock()
+	return group.conf
+}
+
+// free the slot of the client which failed to dispatch the snapshot
+func (mgr *JobGroupManager) releaseSlot(name string, clientName string, snapshotId string) {
+	mgr.lk.RLock()
+	group, ok := mgr.groups[GroupConfPath+name]
+	mgr.lk.RUnlock()
+	if ok {
+		group.releaseSlot(clientName, snapshotId)
+	}
+}
+
+// select all the clients of the group which match the job conf
+func (mgr *JobGroupManager) selectClients(name string, conf *JobConf) (clients []*Client, err error) {
+	var (
+		group *Group
+		ok    bool
+	)
+	mgr.lk.RLock()
+	group, ok = mgr.groups[GroupConfPath+name]
+	mgr.lk.RUnlock()
+	if !ok {
+		err = fmt.Errorf("the group: %s not found", name)
+		return
+	}
+	group.lk.RLock()
+	defer group.lk.RUnlock()
+	return group.candidates(conf)
 }
 
 type Group struct {
 	path       string
 	name       string
 	node       *JobNode
+	conf       *GroupConf
+	selector   ClientSelector
 	watchPath  string
 	clients    map[string]*Client
+	ring       *hashRing
 	watcher    clientv3.Watcher
 	cancelFunc context.CancelFunc
 	lk         *sync.RWMutex
+
+	// the snapshots given to the clients by the client name
+	snapshotPath       string
+	assigned           map[string]map[string]bool
+	snapshotWatcher    clientv3.Watcher
+	snapshotCancelFunc context.CancelFunc
+
+	// the cordoned client names
+	cordonPath       string
+	cordoned         map[string]bool
+	cordonWatcher    clientv3.Watcher
+	cordonCancelFunc context.CancelFunc
 }
 
 // create a new group
-func NewGroup(name, path string, node *JobNode) (group *Group) {
+func NewGroup(groupConf *GroupConf, path string, node *JobNode) (group *Group) {
 	group = &Group{
-		name:      name,
+		name:      groupConf.Name,
 		path:      path,
 		node:      node,
-		watchPath: fmt.Sprintf(ClientPath, name),
+		watchPath: fmt.Sprintf(ClientPath, groupConf.Name),
 		clients:   make(map[string]*Client),
 		lk:        &sync.RWMutex{},
+
+		snapshotPath: fmt.Sprintf(JobSnapshotGroupPath, groupConf.Name),
+		assigned:     make(map[string]map[string]bool),
+
+		cordonPath: JobCordonPath + groupConf.Name + "/",
+		cordoned:   make(map[string]bool),
 	}
+	group.setConf(groupConf)
 	go group.watchClientPath()
 	go group.loopLoadAllClient()
+	go group.watchSnapshotPath()
+	go group.watchCordonPath()
 	return
 }
 
+// set the group conf
+func (group *Group) setConf(groupConf *GroupConf) {
+	selector, err := NewClientSelector(groupConf.Selector)
+	if err != nil {
+		log.Warnf("the group: %s, %v, use the random selector", groupConf.Name, err)
+		selector = &RandomSelector{}
+	}
+	group.lk.Lock()
+	defer group.lk.Unlock()
+	if group.conf == nil || group.conf.Selector != groupConf.Selector {
+		group.selector = selector
+	}
+	group.conf = groupConf
+}
+
 // watch the client path
 func (group *Group) watchClientPath() {
 	keyChangeEventResponse := group.node.etcd.WatchWithPrefixKey(group.watchPath)
@@ -200,12 +319,16 @@ RETRY:
 
 	for index, key := range keys {
 		path := string(key)
-		value := string(values[index])
-		if value == "" {
+		if len(values[index]) == 0 {
 			log.Warnf("the client value is nil for path: %s", path)
 			continue
 		}
-		group.addClient(value, path)
+		meta, err := group.unpackClientMeta(path, values[index])
+		if err != nil {
+			log.Warnf("unpack the client value for path: %s error: %v", path, err)
+			continue
+		}
+		group.addClient(meta, path)
 	}
 }
 
@@ -214,32 +337,77 @@ func (group *Group) handleClientChangeEvent(changeEvent *etcdevent.KeyChangeEven
 	switch changeEvent.Type {
 	case etcdevent.KeyCreateChangeEvent:
 		path := changeEvent.Key
-		name := string(changeEvent.Value)
-		group.addClient(name, path)
+		meta, err := group.unpackClientMeta(path, changeEvent.Value)
+		if err != nil {
+			log.Warnf("unpack the client value for path: %s error: %v", path, err)
+			return
+		}
+		group.addClient(meta, path)
 
 	case etcdevent.KeyUpdateChangeEvent:
-		//ignore
+		path := changeEvent.Key
+		meta, err := group.unpackClientMeta(path, changeEvent.Value)
+		if err != nil {
+			log.Warnf("unpack the client value for path: %s error: %v", path, err)
+			return
+		}
+		group.updateClient(meta, path)
+
 	case etcdevent.KeyDeleteChangeEvent:
 		path := changeEvent.Key
 		group.deleteClient(path)
 	}
 }
 
+// unpack the client registration value, the client registered without the name is named by the last part of the path
+func (group *Group) unpackClientMeta(path string, value []byte) (meta *ClientMeta, err error) {
+	if meta, err = UnpackClientMeta(value); err != nil {
+		return
+	}
+	if len(meta.Name) == 0 {
+		meta.Name = path[strings.LastIndex(path, "/")+1:]
+	}
+	if len(meta.Name) == 0 {
+		err = fmt.Errorf("the client of the path: %s has no name", path)
+	}
+	return
+}
+
 // add  a new  client
-func (group *Group) addClient(name, path string) {
+func (group *Group) addClient(meta *ClientMeta, path string) {
 	group.lk.Lock()
 	defer group.lk.Unlock()
 
 	if _, ok := group.clients[path]; ok {
-		log.Warnf("name: %s, path: %s, the client exist", name, path)
+		log.Warnf("name: %s, path: %s, the client exist", meta.Name, path)
 		return
 	}
 	client := &Client{
-		name: name,
+		name: meta.Name,
 		path: path,
+		meta: meta,
 	}
 	group.clients[path] = client
+	group.rebuildRing()
 	log.Infof("add a new client for path: %s", path)
+	go group.node.pending.drain(group.name)
+}
+
+// update the metadata of the client
+func (group *Group) updateClient(meta *ClientMeta, path string) {
+	group.lk.Lock()
+	defer group.lk.Unlock()
+
+	client, ok := group.clients[path]
+	if !ok {
+		log.Warnf("name: %s, path: %s, the client not exist", meta.Name, path)
+		return
+	}
+	if meta.Name != client.name {
+		log.Warnf("path: %s, the client name can not change from %s to %s", path, client.name, meta.Name)
+		meta.Name = client.name
+	}
+	client.meta = meta
 }
 
 // delete a client for path
@@ -255,6 +423,7 @@ func (group *Group) deleteClient(path string) {
 		return
 	}
 	delete(group.clients, path)
+	group.rebuildRing()
 	log.Infof("delete a client for path: %s", path)
 	// fail over
 	if group.node.state == NodeLeaderState {
@@ -262,25 +431,74 @@ func (group *Group) deleteClient(path string) {
 	}
 }
 
-func (group *Group) selectClient() (client *Client, err error) {
-	group.lk.RLock()
-	defer group.lk.RUnlock()
+// rebuild the hash ring over the client names, must hold the lock
+func (group *Group) rebuildRing() {
+	names := make([]string, 0, len(group.clients))
+	for _, c := range group.clients {
+		names = append(names, c.name)
+	}
+	group.ring = newHashRing(names)
+}
+
+// select a client which has free slot and take the slot for the snapshot
+func (group *Group) selectClient(snapshot *JobSnapshot, conf *JobConf) (client *Client, err error) {
+	group.lk.Lock()
+	defer group.lk.Unlock()
 
+	var clients []*Client
+	if clients, err = group.candidates(conf); err != nil {
+		return
+	}
+	if clients, err = group.freeClients(clients); err != nil {
+		return
+	}
+	selector := group.selector
+	if group.conf.Affinity || (conf != nil && conf.Affinity) {
+		selector = &HashSelector{}
+	}
+	if client, err = selector.Select(group, clients, snapshot); err != nil {
+		return
+	}
+	group.assign(client.name, snapshot.Id)
+	return
+}
+
+// the clients which can run the job sorted by the path, must hold the lock
+func (group *Group) candidates(conf *JobConf) (clients []*Client, err error) {
 	if len(group.clients) == 0 {
-		err = fmt.Errorf("the group: %s, has no client to select", group.name)
+		err = fmt.Errorf("the group: %s, %w", group.name, ErrNoClient)
 		return
 	}
 
-	num := len(group.clients)
-	pos := rand.Intn(num)
-	index := 0
-	for _, c := range group.clients {
-		if index == pos {
-			client = c
+	var labelSelector LabelSelector
+	if conf != nil && len(conf.LabelSelector) > 0 {
+		if labelSelector, err = ParseLabelSelector(conf.LabelSelector); err != nil {
 			return
 		}
-		index++
 	}
+	clients = make([]*Client, 0, len(group.clients))
+	cordoned := 0
+	for _, c := range group.clients {
+		if group.cordoned[c.name] {
+			cordoned++
+			continue
+		}
+		if !labelSelector.Matches(c.labels()) {
+			continue
+		}
+		clients = append(clients, c)
+	}
+	if cordoned == len(group.clients) {
+		err = fmt.Errorf("the group: %s, all the clients are cordoned, %w", group.name, ErrNoClient)
+		return
+	}
+	if len(clients) == 0 {
+		err = fmt.Errorf("the group: %s, %w: %s", group.name, ErrNoClientMatched, conf.LabelSelector)
+		return
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].path < clients[j].path
+	})
 	return
 }
 
@@ -288,4 +506,21 @@ func (group *Group) selectClient() (client *Client, err error) {
 type Client struct {
 	name string
 	path string
+	meta *ClientMeta
+}
+
+// the labels of the client
+func (c *Client) labels() map[string]string {
+	if c.meta == nil {
+		return nil
+	}
+	return c.meta.Labels
+}
+
+// the weight of the client, the default is 1
+func (c *Client) weight() int {
+	if c.meta == nil || c.meta.Weight <= 0 {
+		return 1
+	}
+	return c.meta.Weight
 }
@@ -0,0 +1,56 @@
+package forest
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// hashRingReplicas the virtual nodes of each client on the hash ring
+const hashRingReplicas = 100
+
+// hashRing a consistent hash ring over the client names, only the keys owned by
+// a departed client move to the other clients
+type hashRing struct {
+	hashes []uint32
+	names  map[uint32]string
+}
+
+func newHashRing(names []string) *hashRing {
+	ring := &hashRing{
+		hashes: make([]uint32, 0, len(names)*hashRingReplicas),
+		names:  make(map[uint32]string, len(names)*hashRingReplicas),
+	}
+	for _, name := range names {
+		for i := 0; i < hashRingReplicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + name))
+			if _, ok := ring.names[hash]; ok {
+				continue
+			}
+			ring.names[hash] = name
+			ring.hashes = append(ring.hashes, hash)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+	return ring
+}
+
+// get the name which owns the key, walk along the ring until the name is accepted
+func (ring *hashRing) get(key string, accept func(name string) bool) string {
+	if len(ring.hashes) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	pos := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= hash
+	})
+	for i := 0; i < len(ring.hashes); i++ {
+		name := ring.names[ring.hashes[(pos+i)%len(ring.hashes)]]
+		if accept == nil || accept(name) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package forest
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	names := []string{"192.168.1.1", "192.168.1.2", "192.168.1.3", "192.168.1.4"}
+	ring := newHashRing(names)
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = ring.get(key, nil)
+		counts[owners[key]]++
+	}
+	for _, name := range names {
+		if counts[name] == 0 {
+			t.Fatalf("the name: %s owns no key", name)
+		}
+	}
+
+	// only the keys owned by the departed name move
+	ring = newHashRing(names[1:])
+	for key, owner := range owners {
+		current := ring.get(key, nil)
+		if owner != names[0] && current != owner {
+			t.Fatalf("the key: %s moved from %s to %s", key, owner, current)
+		}
+		if current == names[0] {
+			t.Fatalf("the key: %s still owned by the departed name", key)
+		}
+	}
+
+	if name := ring.get("1", func(name string) bool { return name == names[3] }); name != names[3] {
+		t.Fatalf("the accepted name: %s, expected %s", name, names[3])
+	}
+}
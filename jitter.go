@@ -0,0 +1,59 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"time"
+)
+
+// the jitter spread the fire times of the jobs sharing the same cron, the delay is
+// random on every fire or fixed by the hash of the job id
+
+// check the jitter of the job or the group
+func checkJitter(jitter int, mode string) error {
+	if jitter < 0 {
+		return errors.New("抖动时间不能小于0")
+	}
+	switch mode {
+	case "", JitterRandom, JitterHash:
+	default:
+		return fmt.Errorf("非法的抖动模式: %s", mode)
+	}
+	return nil
+}
+
+// the jitter seconds of the job in [0, jitter]
+func jitterOffset(id string, jitter int, mode string) int {
+	if jitter <= 0 {
+		return 0
+	}
+	if mode == JitterHash {
+		return int(crc32.ChecksumIEEE([]byte(id)) % uint32(jitter+1))
+	}
+	return rand.Intn(jitter + 1)
+}
+
+// add the jitter of the plan to the schedule time, the plan use the jitter of
+// the group if the jitter of the job not set
+func (sch *JobScheduler) jitter(plan *SchedulePlan, scheduleTime time.Time) time.Time {
+	plan.JitterOffset = 0
+	if scheduleTime.IsZero() || plan.delayed != nil {
+		return scheduleTime
+	}
+	jitter, mode := plan.Jitter, plan.JitterMode
+	if jitter <= 0 && sch.node.groupManager != nil {
+		if groupConf := sch.node.groupManager.groupConf(plan.Group); groupConf != nil {
+			jitter, mode = groupConf.Jitter, groupConf.JitterMode
+		}
+	}
+	plan.JitterOffset = jitterOffset(plan.Id, jitter, mode)
+	return scheduleTime.Add(time.Duration(plan.JitterOffset) * time.Second)
+}
+
+// the next time of the plan with the jitter, the next schedule time is computed
+// from the schedule time without the jitter so the delay never skips a run
+func (sch *JobScheduler) nextTime(plan *SchedulePlan, now time.Time) time.Time {
+	return sch.jitter(plan, plan.next(now.Add(-time.Duration(plan.JitterOffset)*time.Second)))
+}
@@ -0,0 +1,67 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoClientMatched no client of the group matches the label selector of the job
+var ErrNoClientMatched = errors.New("no client matches the label selector")
+
+// LabelSelector the requirements on the client labels, such as: gpu=false,zone=a,env!=prod,ssd
+type LabelSelector []labelRequirement
+
+type labelRequirement struct {
+	key   string
+	value string
+	op    string // =, !=, empty means the key exists
+}
+
+// ParseLabelSelector parse the label selector
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var requirements LabelSelector
+	for _, item := range strings.Split(selector, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		requirement := labelRequirement{}
+		if pos := strings.Index(item, "!="); pos != -1 {
+			requirement.key, requirement.value, requirement.op = item[:pos], item[pos+2:], "!="
+		} else if pos := strings.Index(item, "="); pos != -1 {
+			requirement.key, requirement.value, requirement.op = item[:pos], item[pos+1:], "="
+		} else {
+			requirement.key = item
+		}
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if len(requirement.key) == 0 {
+			return nil, fmt.Errorf("the label selector: %s has an empty key", selector)
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+// Matches check the labels match all the requirements
+func (selector LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range selector {
+		value, ok := labels[requirement.key]
+		switch requirement.op {
+		case "=":
+			if !ok || value != requirement.value {
+				return false
+			}
+		case "!=":
+			if ok && value == requirement.value {
+				return false
+			}
+		default:
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
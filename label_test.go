@@ -0,0 +1,88 @@
+package forest
+
+import (
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	cases := []struct {
+		selector     string
+		requirements LabelSelector
+		invalid      bool
+	}{
+		{"", nil, false},
+		{" , ", nil, false},
+		{"ssd", LabelSelector{{key: "ssd"}}, false},
+		{"zone=a", LabelSelector{{key: "zone", value: "a", op: "="}}, false},
+		{"env!=prod", LabelSelector{{key: "env", value: "prod", op: "!="}}, false},
+		{"zone=", LabelSelector{{key: "zone", op: "="}}, false},
+		{" gpu = false , env != prod ,ssd,", LabelSelector{
+			{key: "gpu", value: "false", op: "="},
+			{key: "env", value: "prod", op: "!="},
+			{key: "ssd"},
+		}, false},
+		{"=a", nil, true},
+		{"!=prod", nil, true},
+		{" = a", nil, true},
+		{"zone=a,=b", nil, true},
+		{"=", nil, true},
+	}
+	for _, c := range cases {
+		requirements, err := ParseLabelSelector(c.selector)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("the invalid selector: %q parsed: %v", c.selector, requirements)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse the selector: %q error: %v", c.selector, err)
+			continue
+		}
+		if len(requirements) != len(c.requirements) {
+			t.Errorf("the requirements of the selector: %q: %v, expected %v", c.selector, requirements, c.requirements)
+			continue
+		}
+		for i, requirement := range requirements {
+			if requirement != c.requirements[i] {
+				t.Errorf("the requirement of the selector: %q: %v, expected %v", c.selector, requirement, c.requirements[i])
+			}
+		}
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"gpu": "false", "zone": "a", "env": "test", "ssd": ""}
+	cases := []struct {
+		selector string
+		matched  bool
+	}{
+		{"", true},
+		{"ssd", true},
+		{"nvme", false},
+		{"zone=a", true},
+		{"zone=b", false},
+		{"region=", false},
+		{"ssd=", true},
+		{"env!=prod", true},
+		{"env!=test", false},
+		{"region!=a", true},
+		{"gpu=false,zone=a,env!=prod,ssd", true},
+		{"gpu=false,zone=b", false},
+	}
+	for _, c := range cases {
+		selector, err := ParseLabelSelector(c.selector)
+		if err != nil {
+			t.Fatalf("parse the selector: %q error: %v", c.selector, err)
+		}
+		if matched := selector.Matches(labels); matched != c.matched {
+			t.Errorf("the selector: %q matched: %v, expected %v", c.selector, matched, c.matched)
+		}
+	}
+	if !LabelSelector(nil).Matches(nil) {
+		t.Error("the empty selector should match the client without labels")
+	}
+	if selector, _ := ParseLabelSelector("ssd"); selector.Matches(nil) {
+		t.Error("the client without labels matched the existence requirement")
+	}
+}
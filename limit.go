@@ -0,0 +1,350 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/webx-top/db"
+)
+
+// the max in-flight executions of the group and the job, the excess snapshots wait in
+// the durable waiting queue and the leader release them when the executions finished
+
+const (
+	JobWaitingPath = "/forest/server/waiting/" // + group/snapshot.id
+)
+
+var (
+	// errSnapshotWaiting the snapshot is put into the waiting queue
+	errSnapshotWaiting = errors.New("the snapshot is put into the waiting queue")
+	// errGroupLimit the group has reached the max concurrent
+	errGroupLimit = errors.New("the group has reached the max concurrent")
+	// errJobLimit the job has reached the max concurrent
+	errJobLimit = errors.New("the job has reached the max concurrent")
+	// errLimitOnLeader only the leader track the in-flight executions
+	errLimitOnLeader = errors.New("the max concurrent and the concurrency policy are checked on the leader")
+)
+
+// check the snapshot is parked in the waiting queue or the pending area instead of failed
+func isSnapshotParked(err error) bool {
+	return errors.Is(err, errSnapshotWaiting) || errors.Is(err, errSnapshotPending)
+}
+
+// JobLimiter limit the in-flight executions of the group and the job
+type JobLimiter struct {
+	node      *JobNode
+	running   map[string]*limitEntry // the in-flight snapshots by the id
+	groups    map[string]int         // the in-flight count by the group
+	jobs      map[string]int         // the in-flight count by the job id
+	lk        *sync.Mutex
+	releaseLk *sync.Mutex
+}
+
+type limitEntry struct {
+	id    string
+	group string
+	jobId string
+	ip    string // the client given the snapshot, empty for the parent of the shards
+}
+
+func NewJobLimiter(node *JobNode) (limiter *JobLimiter) {
+	limiter = &JobLimiter{
+		node:      node,
+		running:   make(map[string]*limitEntry),
+		groups:    make(map[string]int),
+		jobs:      make(map[string]int),
+		lk:        &sync.Mutex{},
+		releaseLk: &sync.Mutex{},
+	}
+	go limiter.loop()
+	return
+}
+
+// the max concurrent of the group and the job
+func (limiter *JobLimiter) limits(group string, conf *JobConf) (groupMax int, jobMax int) {
+	if groupConf := limiter.node.groupManager.groupConf(group); groupConf != nil {
+		groupMax = groupConf.MaxConcurrent
+	}
+	if conf != nil {
+		jobMax = conf.MaxConcurrent
+	}
+	return
+}
+
+// acquire a slot for the snapshot, return errGroupLimit or errJobLimit if the group or the job has reached the max concurrent,
+// the followers never see the executions finished so they leave the limited snapshots to the leader
+func (limiter *JobLimiter) acquire(snapshot *JobSnapshot, conf *JobConf) error {
+	groupMax, jobMax := limiter.limits(snapshot.Group, conf)
+	if limiter.node.state != NodeLeaderState {
+		if groupMax > 0 || jobMax > 0 {
+			return errLimitOnLeader
+		}
+		return nil
+	}
+	limiter.lk.Lock()
+	defer limiter.lk.Unlock()
+	if _, ok := limiter.running[snapshot.Id]; ok {
+		return nil
+	}
+	if groupMax > 0 && limiter.groups[snapshot.Group] >= groupMax {
+		return errGroupLimit
+	}
+	if jobMax > 0 && len(snapshot.JobId) > 0 && limiter.jobs[snapshot.JobId] >= jobMax {
+		return errJobLimit
+	}
+	limiter.add(snapshot.Id, snapshot.Group, snapshot.JobId, "")
+	return nil
+}
+
+// add the in-flight snapshot, must hold the lock
+func (limiter *JobLimiter) add(id string, group string, jobId string, ip string) {
+	if entry, ok := limiter.running[id]; ok {
+		if len(ip) > 0 {
+			entry.ip = ip
+		}
+		return
+	}
+	limiter.running[id] = &limitEntry{id: id, group: group, jobId: jobId, ip: ip}
+	limiter.groups[group]++
+	if len(jobId) > 0 {
+		limiter.jobs[jobId]++
+	}
+}
+
+// track the snapshot given to the client or the execution reported doing by the client
+func (limiter *JobLimiter) track(id string, group string, jobId string, ip string) {
+	limiter.lk.Lock()
+	limiter.add(id, group, jobId, ip)
+	limiter.lk.Unlock()
+}
+
+// the in-flight executions of the job except the snapshot itself, include the snapshots not started by the clients yet
+func (limiter *JobLimiter) jobSnapshots(jobId string, except string) (entries []*limitEntry) {
+	limiter.lk.Lock()
+	defer limiter.lk.Unlock()
+	if limiter.jobs[jobId] == 0 {
+		return
+	}
+	for id, entry := range limiter.running {
+		if entry.jobId == jobId && id != except {
+			copied := *entry
+			entries = append(entries, &copied)
+		}
+	}
+	return
+}
+
+// the execution of the snapshot finished or the snapshot not given to the client
+func (limiter *JobLimiter) done(id string) {
+	limiter.lk.Lock()
+	defer limiter.lk.Unlock()
+	entry, ok := limiter.running[id]
+	if !ok {
+		return
+	}
+	delete(limiter.running, id)
+	if limiter.groups[entry.group]--; limiter.groups[entry.group] <= 0 {
+		delete(limiter.groups, entry.group)
+	}
+	if len(entry.jobId) > 0 {
+		if limiter.jobs[entry.jobId]--; limiter.jobs[entry.jobId] <= 0 {
+			delete(limiter.jobs, entry.jobId)
+		}
+	}
+}
+
+// the in-flight executions of the job
+func (limiter *JobLimiter) inflight(jobId string) int {
+	limiter.lk.Lock()
+	defer limiter.lk.Unlock()
+	return limiter.jobs[jobId]
+}
+
+// rebuild the in-flight executions when the node become the leader
+func (limiter *JobLimiter) notify(state int) {
+	if state != NodeLeaderState {
+		return
+	}
+	limiter.rebuild()
+}
+
+// rebuild the in-flight executions from the snapshots not started by the clients yet,
+// the executions reported doing and the parents of the shards which only recorded in the db
+func (limiter *JobLimiter) rebuild() {
+	limiter.lk.Lock()
+	defer limiter.lk.Unlock()
+	limiter.running = make(map[string]*limitEntry)
+	limiter.groups = make(map[string]int)
+	limiter.jobs = make(map[string]int)
+
+	if _, values, err := limiter.node.etcd.GetWithPrefixKey(JobSnapshotPath); err != nil {
+		log.Errorf("load the dispatched snapshots error: %v", err)
+	} else {
+		for _, value := range values {
+			if snapshot, err := UnpackJobSnapshot(value); err == nil && !snapshot.isShard() {
+				limiter.add(snapshot.Id, snapshot.Group, snapshot.JobId, snapshot.Ip)
+			}
+		}
+	}
+	// the clients may not report the shard fields, the shards are recorded in the db when dispatched
+	var shards []*JobExecuteSnapshot
+	err := limiter.node.UseTable(TableJobExecuteSnapshot).
+		Find(db.Cond{
+			`status`:         JobExecuteSnapshotDoingStatus,
+			`shard_index >=`: 0,
+			`shard_total >`:  0,
+			`create_time >=`: ToDateString(time.Now().AddDate(0, 0, -3)),
+		}).
+		All(&shards)
+	if err != nil {
+		log.Errorf("load the doing shards error: %v", err)
+	}
+	shardIds := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		shardIds[shard.Id] = true
+	}
+	if _, values, err := limiter.node.etcd.GetWithPrefixKey(JobExecuteStatusCollectionPath); err != nil {
+		log.Errorf("load the execute snapshots error: %v", err)
+	} else {
+		for _, value := range values {
+			executeSnapshot, err := UnpackJobExecuteSnapshot(value)
+			if err == nil && executeSnapshot.Status == JobExecuteSnapshotDoingStatus && !executeSnapshot.isShard() && !shardIds[executeSnapshot.Id] {
+				limiter.add(executeSnapshot.Id, executeSnapshot.Group, executeSnapshot.JobId, executeSnapshot.Ip)
+			}
+		}
+	}
+	var parents []*JobExecuteSnapshot
+	err = limiter.node.UseTable(TableJobExecuteSnapshot).
+		Find(db.Cond{
+			`status`:         JobExecuteSnapshotDoingStatus,
+			`shard_index`:    -1,
+			`create_time >=`: ToDateString(time.Now().AddDate(0, 0, -3)),
+		}).
+		All(&parents)
+	if err != nil {
+		log.Errorf("load the doing parents of the shards error: %v", err)
+	}
+	for _, parent := range parents {
+		limiter.add(parent.Id, parent.Group, parent.JobId, "")
+	}
+	log.Infof("rebuild the in-flight executions: %d", len(limiter.running))
+}
+
+// put the snapshot into the waiting queue, the cause is kept in the returned error
+func (limiter *JobLimiter) wait(snapshot *JobSnapshot, cause error) error {
+	value, err := PackJobSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("pack the waiting snapshot: %s error: %w", snapshot.Id, err)
+	}
+	if err = limiter.node.etcd.Put(JobWaitingPath+snapshot.Group+"/"+snapshot.Id, string(value)); err != nil {
+		return fmt.Errorf("put the waiting snapshot: %s error: %w", snapshot.Id, err)
+	}
+	log.Warnf("the snapshot: %s of the job: %s is waiting, %v", snapshot.Id, snapshot.JobId, cause)
+	return fmt.Errorf("%w: %w", errSnapshotWaiting, cause)
+}
+
+// the action on the waiting snapshot handled again, keep the key if it is still waiting
+// and handle the next one unless the group is full or has no client
+func releaseAction(err error) (keep bool, next bool) {
+	switch {
+	case errors.Is(err, errSnapshotWaiting):
+		return true, errors.Is(err, errJobLimit)
+	case errors.Is(err, errSnapshotPending):
+		return false, false
+	default:
+		return false, true
+	}
+}
+
+// release the waiting snapshots of the group in order, the snapshots of the job which has reached the max
+// concurrent are skipped, the key is deleted only after the snapshot left the waiting queue
+func (limiter *JobLimiter) release(group string) {
+	if limiter.node.state != NodeLeaderState {
+		return
+	}
+	limiter.releaseLk.Lock()
+	defer limiter.releaseLk.Unlock()
+	keys, values, err := limiter.node.etcd.GetWithPrefixKey(JobWaitingPath + group + "/")
+	if err != nil {
+		log.Errorf("load the waiting snapshots of the group: %s error: %v", group, err)
+		return
+	}
+	blocked := make(map[string]bool) // the jobs which have reached the max concurrent
+	for index, key := range keys {
+		snapshot, err := UnpackJobSnapshot(values[index])
+		if err != nil {
+			log.Errorf("unpack the waiting snapshot: %s error: %v", key, err)
+			_ = limiter.node.etcd.Delete(string(key))
+			continue
+		}
+		if len(snapshot.JobId) > 0 && blocked[snapshot.JobId] {
+			continue
+		}
+		err = limiter.node.exec.handleJobSnapshot(snapshot)
+		keep, next := releaseAction(err)
+		if !keep {
+			if err := limiter.node.etcd.Delete(string(key)); err != nil {
+				log.Errorf("delete the waiting snapshot: %s error: %v", snapshot.Id, err)
+			}
+		}
+		if err != nil && !isSnapshotParked(err) {
+			log.Error(err)
+		}
+		if !next {
+			return
+		}
+		if keep {
+			blocked[snapshot.JobId] = true
+		}
+	}
+}
+
+// release the waiting snapshots of all the groups on the leader periodically, the completions may
+// be missed during the failover and the followers leave the limited snapshots here
+func (limiter *JobLimiter) loop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if limiter.node.state != NodeLeaderState {
+			continue
+		}
+		keys, _, err := limiter.node.etcd.GetWithPrefixKey(JobWaitingPath)
+		if err != nil {
+			log.Errorf("load the waiting snapshots error: %v", err)
+			continue
+		}
+		groups := make(map[string]bool)
+		for _, key := range keys {
+			if group := strings.SplitN(strings.TrimPrefix(string(key), JobWaitingPath), "/", 2)[0]; !groups[group] {
+				groups[group] = true
+				limiter.release(group)
+			}
+		}
+	}
+}
+
+// WaitingSnapshotList the snapshots of the group waiting for the concurrency limit or the free slot of the clients
+func (manager *JobManager) WaitingSnapshotList(group string) (snapshots []*JobSnapshot, err error) {
+	var values [][]byte
+	prefix := JobWaitingPath
+	if len(group) > 0 {
+		prefix += group + "/"
+	}
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(prefix); err != nil {
+		return
+	}
+	snapshots = make([]*JobSnapshot, 0, len(values))
+	for _, value := range values {
+		snapshot, err := UnpackJobSnapshot(value)
+		if err != nil {
+			log.Errorf("unpack the waiting snapshot error: %#v", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return
+}
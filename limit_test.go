@@ -0,0 +1,113 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newTestLimiter(groupMax int) *JobLimiter {
+	node := &JobNode{state: NodeLeaderState}
+	node.groupManager = &JobGroupManager{
+		node: node,
+		groups: map[string]*Group{
+			GroupConfPath + "g": {name: "g", conf: &GroupConf{Name: "g", MaxConcurrent: groupMax}, lk: &sync.RWMutex{}},
+		},
+		lk: &sync.RWMutex{},
+	}
+	return &JobLimiter{
+		node:      node,
+		running:   make(map[string]*limitEntry),
+		groups:    make(map[string]int),
+		jobs:      make(map[string]int),
+		lk:        &sync.Mutex{},
+		releaseLk: &sync.Mutex{},
+	}
+}
+
+func TestJobLimiterAcquire(t *testing.T) {
+	limiter := newTestLimiter(3)
+	conf := &JobConf{Id: "a", MaxConcurrent: 2}
+	for _, id := range []string{"1", "2"} {
+		if err := limiter.acquire(&JobSnapshot{Id: id, Group: "g", JobId: "a"}, conf); err != nil {
+			t.Fatalf("acquire the snapshot: %s error: %v", id, err)
+		}
+	}
+	if err := limiter.acquire(&JobSnapshot{Id: "3", Group: "g", JobId: "a"}, conf); !errors.Is(err, errJobLimit) {
+		t.Fatalf("the job limit not reached: %v", err)
+	}
+	if err := limiter.acquire(&JobSnapshot{Id: "1", Group: "g", JobId: "a"}, conf); err != nil {
+		t.Fatalf("the acquired snapshot should not count twice: %v", err)
+	}
+	if err := limiter.acquire(&JobSnapshot{Id: "4", Group: "g", JobId: "b"}, nil); err != nil {
+		t.Fatalf("acquire the snapshot of the other job error: %v", err)
+	}
+	if err := limiter.acquire(&JobSnapshot{Id: "5", Group: "g", JobId: "c"}, nil); !errors.Is(err, errGroupLimit) {
+		t.Fatalf("the group limit not reached: %v", err)
+	}
+	limiter.done("1")
+	limiter.done("1")
+	if limiter.groups["g"] != 2 || limiter.jobs["a"] != 1 {
+		t.Fatalf("the in-flight count: %d, %d", limiter.groups["g"], limiter.jobs["a"])
+	}
+	if err := limiter.acquire(&JobSnapshot{Id: "3", Group: "g", JobId: "a"}, conf); err != nil {
+		t.Fatalf("the slot not freed: %v", err)
+	}
+
+	limiter.node.state = NodeFollowerState
+	if err := limiter.acquire(&JobSnapshot{Id: "6", Group: "g", JobId: "a"}, conf); !errors.Is(err, errLimitOnLeader) {
+		t.Fatalf("the follower should leave the limited snapshot to the leader: %v", err)
+	}
+}
+
+func TestReleaseAction(t *testing.T) {
+	cases := []struct {
+		err  error
+		keep bool
+		next bool
+	}{
+		{nil, false, true},
+		{errors.New("dispatch error"), false, true},
+		{fmt.Errorf("%w: %w", errSnapshotWaiting, errJobLimit), true, true},
+		{fmt.Errorf("%w: %w", errSnapshotWaiting, errGroupLimit), true, false},
+		{fmt.Errorf("%w: %w", errSnapshotWaiting, ErrNoFreeSlot), true, false},
+		{errSnapshotPending, false, false},
+	}
+	for _, c := range cases {
+		if keep, next := releaseAction(c.err); keep != c.keep || next != c.next {
+			t.Errorf("the action of: %v, keep: %v, next: %v", c.err, keep, next)
+		}
+	}
+}
+
+func TestConcurrencyPolicy(t *testing.T) {
+	limiter := newTestLimiter(0)
+	if err := limiter.acquire(&JobSnapshot{Id: "1", Group: "g", JobId: "a"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// dispatched but not started by the client yet
+	limiter.track("1", "g", "a", "c1")
+	limiter.track("2", "g", "a", "c2")
+	limiter.track("3", "g", "b", "c1")
+
+	running := limiter.jobSnapshots("a", "2")
+	if len(running) != 1 || running[0].id != "1" || running[0].ip != "c1" {
+		t.Fatalf("the in-flight snapshots of the job: %v", running)
+	}
+	running = limiter.jobSnapshots("a", "new")
+	if skip, replaced := concurrencyAction(ConcurrencyForbid, running); !skip || replaced != nil {
+		t.Fatalf("the forbid policy not skip the snapshot: %v, %v", skip, replaced)
+	}
+	if skip, replaced := concurrencyAction(ConcurrencyReplace, running); skip || len(replaced) != 2 {
+		t.Fatalf("the replace policy not replace the in-flight snapshots: %v, %v", skip, replaced)
+	}
+	if skip, replaced := concurrencyAction(ConcurrencyAllow, running); skip || replaced != nil {
+		t.Fatalf("the allow policy: %v, %v", skip, replaced)
+	}
+	limiter.done("1")
+	limiter.done("2")
+	if skip, replaced := concurrencyAction(ConcurrencyForbid, limiter.jobSnapshots("a", "new")); skip || replaced != nil {
+		t.Fatalf("the finished executions still block the snapshot: %v, %v", skip, replaced)
+	}
+}
@@ -26,6 +26,7 @@ func NewJobManager(node *JobNode) (manager *JobManager) {
 		node: node,
 	}
 	go manager.watchJobConfPath()
+	go manager.watchDelayedTaskPath()
 	return
 }
 
@@ -145,6 +146,10 @@ func (manager *JobManager) AddJob(jobConf *JobConf) (err error) {
 		err = errors.New("任务集群不存在")
 		return
 	}
+	jobConf.CronVersion = CronVersion
+	if err = manager.checkJobConf(jobConf); err != nil {
+		return
+	}
 	jobConf.Id = GenerateSerialNo()
 	jobConf.Version = 1
 	if v, err = PackJobConf(jobConf); err != nil {
@@ -189,7 +194,18 @@ func (manager *JobManager) EditJob(jobConf *JobConf) (err error) {
 	if oldConf, err = UnpackJobConf([]byte(value)); err != nil {
 		return
 	}
+	// keep the legacy semantics until the cron changed
+	jobConf.CronVersion = CronVersion
+	if jobConf.Cron == oldConf.Cron {
+		jobConf.CronVersion = oldConf.CronVersion
+	}
+	if err = manager.checkJobConf(jobConf); err != nil {
+		return
+	}
 	jobConf.Version = oldConf.Version + 1
+	if jobConf.Status == JobRunningStatus {
+		jobConf.StopReason = ``
+	}
 	if v, err = PackJobConf(jobConf); err != nil {
 		return
 	}
@@ -203,6 +219,129 @@ func (manager *JobManager) EditJob(jobConf *JobConf) (err error) {
 	return
 }
 
+// check the job conf before save
+func (manager *JobManager) checkJobConf(jobConf *JobConf) (err error) {
+	switch jobConf.ScheduleType {
+	case "", ScheduleCron:
+		if _, err = parseJobCron(jobConf); err != nil {
+			err = fmt.Errorf("非法的Cron表达式: %v", err)
+			return
+		}
+	case ScheduleFixedRate, ScheduleFixedDelay:
+		if jobConf.Interval <= 0 {
+			err = errors.New("固定频率和固定延迟调度的间隔时间必须大于0")
+			return
+		}
+	default:
+		err = fmt.Errorf("非法的调度类型: %s", jobConf.ScheduleType)
+		return
+	}
+	if _, err = LoadLocation(jobConf.Timezone); err != nil {
+		err = fmt.Errorf("非法的时区: %s", jobConf.Timezone)
+		return
+	}
+	switch jobConf.MisfirePolicy {
+	case "", MisfireSkip, MisfireFireOnce, MisfireFireAll:
+	default:
+		err = fmt.Errorf("非法的错过执行策略: %s", jobConf.MisfirePolicy)
+		return
+	}
+	if jobConf.MisfireMaxRuns < 0 || jobConf.MisfireGrace < 0 {
+		err = errors.New("错过执行的补偿次数和宽限时间不能小于0")
+		return
+	}
+	switch jobConf.Concurrency {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		err = fmt.Errorf("非法的并发执行策略: %s", jobConf.Concurrency)
+		return
+	}
+	switch jobConf.RetryBackoff {
+	case "", RetryBackoffFixed, RetryBackoffExponential:
+	default:
+		err = fmt.Errorf("非法的重试退避策略: %s", jobConf.RetryBackoff)
+		return
+	}
+	if jobConf.RetryMax < 0 || jobConf.RetryInterval < 0 {
+		err = errors.New("重试次数和重试间隔不能小于0")
+		return
+	}
+	if jobConf.Timeout < 0 {
+		err = errors.New("执行超时时间不能小于0")
+		return
+	}
+	if _, err = ParseLabelSelector(jobConf.LabelSelector); err != nil {
+		err = fmt.Errorf("非法的客户端标签选择器: %s", jobConf.LabelSelector)
+		return
+	}
+	switch jobConf.Mode {
+	case "", JobModeBroadcast:
+	case JobModeSharding:
+		if jobConf.ShardTotal <= 0 || jobConf.ShardTotal > ShardTotalLimit {
+			err = fmt.Errorf("分片总数必须在1到%d之间", ShardTotalLimit)
+			return
+		}
+		if len(jobConf.ShardParams) > jobConf.ShardTotal {
+			err = errors.New("分片参数的数量不能超过分片总数")
+			return
+		}
+	default:
+		err = fmt.Errorf("非法的任务执行模式: %s", jobConf.Mode)
+		return
+	}
+	location, _ := LoadLocation(jobConf.Timezone)
+	var startAt, endAt time.Time
+	if startAt, err = parseJobTime(jobConf.StartAt, location); err != nil {
+		err = fmt.Errorf("非法的开始时间: %s", jobConf.StartAt)
+		return
+	}
+	if endAt, err = parseJobTime(jobConf.EndAt, location); err != nil {
+		err = fmt.Errorf("非法的结束时间: %s", jobConf.EndAt)
+		return
+	}
+	if !startAt.IsZero() && !endAt.IsZero() && !startAt.Before(endAt) {
+		err = errors.New("开始时间必须早于结束时间")
+		return
+	}
+	if jobConf.MaxRuns < 0 {
+		err = errors.New("最大执行次数不能小于0")
+		return
+	}
+	if err = checkJitter(jobConf.Jitter, jobConf.JitterMode); err != nil {
+		return
+	}
+	if jobConf.MaxConcurrent < 0 {
+		err = errors.New("最大并发数不能小于0")
+		return
+	}
+	if jobConf.MaxWait < 0 {
+		err = errors.New("等待客户端的最长时间不能小于0")
+		return
+	}
+	if err = checkParamsTemplate(jobConf.Params); err != nil {
+		err = fmt.Errorf("非法的参数模板: %v", err)
+		return
+	}
+	switch jobConf.CalendarPolicy {
+	case "", CalendarSkip, CalendarDefer:
+	default:
+		err = fmt.Errorf("非法的日历排除策略: %s", jobConf.CalendarPolicy)
+		return
+	}
+	if len(jobConf.Calendar) > 0 {
+		var value []byte
+		if value, err = manager.node.etcd.Get(CalendarPath + jobConf.Calendar); err != nil {
+			return
+		}
+		if len(value) == 0 {
+			err = fmt.Errorf("日历不存在: %s", jobConf.Calendar)
+			return
+		}
+	}
+	err = manager.checkJobChain(jobConf)
+	return
+}
+
 // delete job conf
 func (manager *JobManager) DeleteJob(jobConf *JobConf) (err error) {
 	var value []byte
@@ -217,7 +356,10 @@ func (manager *JobManager) DeleteJob(jobConf *JobConf) (err error) {
 		err = errors.New("此任务配置记录不存在")
 		return
 	}
-	err = manager.node.etcd.Delete(JobConfPath + jobConf.Id)
+	if err = manager.node.etcd.Delete(JobConfPath + jobConf.Id); err != nil {
+		return
+	}
+	err = manager.node.etcd.Delete(JobFireStatePath + jobConf.Id)
 	return
 }
 
@@ -248,6 +390,9 @@ func (manager *JobManager) AddGroup(groupConf *GroupConf) (err error) {
 		value   []byte
 		success bool
 	)
+	if err = manager.checkGroupConf(groupConf); err != nil {
+		return
+	}
 	if value, err = PackGroupConf(groupConf); err != nil {
 		return
 	}
@@ -274,6 +419,9 @@ func (manager *JobManager) EditGroup(groupConf *GroupConf) (err error) {
 		err = errors.New("此任务集群不存在")
 		return
 	}
+	if err = manager.checkGroupConf(groupConf); err != nil {
+		return
+	}
 	if newV, err = PackGroupConf(groupConf); err != nil {
 		return
 	}
@@ -286,6 +434,21 @@ func (manager *JobManager) EditGroup(groupConf *GroupConf) (err error) {
 	return
 }
 
+// check the group conf before save
+func (manager *JobManager) checkGroupConf(groupConf *GroupConf) (err error) {
+	if _, err = NewClientSelector(groupConf.Selector); err != nil {
+		err = fmt.Errorf("非法的客户端选择策略: %s", groupConf.Selector)
+		return
+	}
+	if err = checkJitter(groupConf.Jitter, groupConf.JitterMode); err != nil {
+		return
+	}
+	if groupConf.MaxConcurrent < 0 {
+		err = errors.New("最大并发数不能小于0")
+	}
+	return
+}
+
 // delete group
 func (manager *JobManager) DeleteGroup(groupConf *GroupConf) (err error) {
 	var value []byte
@@ -341,25 +504,36 @@ func (manager *JobManager) NodeList() (nodes []string, err error) {
 	return
 }
 
-func (manager *JobManager) ManualExecuteJob(jobId string) error {
+// GetJob get the job conf
+func (manager *JobManager) GetJob(jobId string) (conf *JobConf, err error) {
 	// 查询任务配置
 	value, err := manager.node.etcd.Get(JobConfPath + jobId)
 	if err != nil {
-		return fmt.Errorf("查询任务配置出现异常: %w", err)
+		return nil, fmt.Errorf("查询任务配置出现异常: %w", err)
 	}
 
 	// 任务配置是否为空
 	if len(value) == 0 {
-		return errors.New("此任务配置内容为空")
+		return nil, errors.New("此任务配置内容为空")
 	}
 
-	var conf *JobConf
 	conf, err = UnpackJobConf(value)
 	if err != nil {
-		return fmt.Errorf("非法的任务配置内容: %w", err)
+		return nil, fmt.Errorf("非法的任务配置内容: %w", err)
 	}
+	return
+}
 
-	// build job snapshot
+func (manager *JobManager) ManualExecuteJob(jobId string) error {
+	conf, err := manager.GetJob(jobId)
+	if err != nil {
+		return err
+	}
+	return manager.ManualExecute(manager.newJobSnapshot(conf, time.Now()))
+}
+
+// build a job snapshot of the job conf
+func (manager *JobManager) newJobSnapshot(conf *JobConf, logicalTime time.Time) *JobSnapshot {
 	snapshotId := GenerateSerialNo() + conf.Id
 	snapshot := &JobSnapshot{
 		Id:         snapshotId,
@@ -371,8 +545,13 @@ func (manager *JobManager) ManualExecuteJob(jobId string) error {
 		Params:     conf.Params,
 		Remark:     conf.Remark,
 		CreateTime: ToDateString(time.Now()),
+		Priority:   conf.Priority,
 	}
-	return manager.ManualExecute(snapshot)
+	if location, err := LoadLocation(conf.Timezone); err == nil {
+		logicalTime = logicalTime.In(location)
+	}
+	manager.renderSnapshot(snapshot, conf.Vars, logicalTime)
+	return snapshot
 }
 
 // ManualExecute 手动执行任务
@@ -385,7 +564,12 @@ func (manager *JobManager) ManualExecute(snapshot *JobSnapshot) error {
 		snapshot.CreateTime = ToDateString(time.Now())
 	}
 	snapshot.Name = com.Substr(snapshot.Name, ``, 120)
-	return manager.node.exec.handleJobSnapshot(snapshot)
+	err := manager.node.exec.handleJobSnapshot(snapshot)
+	if isSnapshotParked(err) {
+		// dispatch later when the executions finished or a client registered
+		return nil
+	}
+	return err
 }
 
 func (manager *JobManager) Kill(snapshot *JobSnapshot) (err error) {
@@ -0,0 +1,190 @@
+package forest
+
+import (
+	"strings"
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// the last fire state of the jobs, the new leader detects the missed runs from it
+
+const (
+	JobFireStatePath = "/forest/server/fire/" // + job.id
+)
+
+// MisfireFireAllLimit the hard limit of the missed runs to fire for the fire-all policy
+var MisfireFireAllLimit = 100
+
+// misfireScanLimit the limit of the missed schedule times to enumerate for a plan
+const misfireScanLimit = 1000
+
+// record the fire time of the plan, must hold the lock, the state is written
+// before the fires dispatched so the scheduler never wait for the etcd while holding the lock
+func (sch *JobScheduler) recordFire(plan *SchedulePlan, fireTime time.Time) {
+	sch.runs[plan.Id] = plan.Runs
+	sch.fireStates[plan.Id] = &JobFireState{
+		JobId:    plan.Id,
+		FireTime: fireTime.Format(time.RFC3339),
+		Runs:     plan.Runs,
+	}
+}
+
+// write the fire states recorded since the last write, must not hold the lock, the writes are
+// serialized so the fires of the other goroutine never dispatched before their states written
+func (sch *JobScheduler) writeFireStates() {
+	sch.fireLk.Lock()
+	defer sch.fireLk.Unlock()
+	sch.lk.Lock()
+	states := sch.fireStates
+	sch.fireStates = make(map[string]*JobFireState)
+	sch.lk.Unlock()
+	failed := make(map[string]*JobFireState)
+	for id, state := range states {
+		value, err := PackJobFireState(state)
+		if err != nil {
+			log.Errorf("pack the fire state of the plan: %s error: %v", id, err)
+			continue
+		}
+		if err = sch.node.etcd.Put(JobFireStatePath+id, string(value)); err != nil {
+			log.Errorf("record the fire state of the plan: %s error: %v", id, err)
+			failed[id] = state
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	// write the failed states again with the next fires unless the newer ones recorded
+	sch.lk.Lock()
+	for id, state := range failed {
+		if _, ok := sch.fireStates[id]; !ok {
+			sch.fireStates[id] = state
+		}
+	}
+	sch.lk.Unlock()
+}
+
+// load the last fire time and the run count of all the jobs
+func (sch *JobScheduler) loadFireTimes() (fireTimes map[string]time.Time, runs map[string]int, err error) {
+	var (
+		keys   [][]byte
+		values [][]byte
+	)
+	if keys, values, err = sch.node.etcd.GetWithPrefixKey(JobFireStatePath); err != nil {
+		return
+	}
+	fireTimes = make(map[string]time.Time, len(keys))
+	runs = make(map[string]int, len(keys))
+	for index, key := range keys {
+		state, err := UnpackJobFireState(values[index])
+		if err != nil {
+			log.Warnf("unpack the fire state: %s error: %v", key, err)
+			continue
+		}
+		runs[state.JobId] = state.Runs
+		fireTime, err := time.Parse(time.RFC3339, state.FireTime)
+		if err != nil {
+			log.Warnf("parse the fire time: %s error: %v", state.FireTime, err)
+			continue
+		}
+		fireTimes[strings.TrimPrefix(string(key), JobFireStatePath)] = fireTime
+	}
+	return
+}
+
+// catch up the runs missed between the last fire time and now, when the node become the leader
+func (sch *JobScheduler) catchUpMisfires() {
+	fireTimes, runs, err := sch.loadFireTimes()
+	if err != nil {
+		log.Errorf("load the fire state error: %v", err)
+		return
+	}
+	now := time.Now()
+	var fired []*scheduleFire
+	sch.lk.Lock()
+	defer func() {
+		sch.lk.Unlock()
+		sch.dispatch(fired)
+	}()
+	sch.runs, sch.runsLoaded = runs, true
+	for id, plan := range sch.schedulePlans {
+		plan.Runs = runs[id]
+		fireTime, ok := fireTimes[id]
+		if !ok || plan.ScheduleType == ScheduleFixedDelay {
+			continue
+		}
+		missed := plan.missedTimes(fireTime, now)
+		if len(missed) == 0 {
+			continue
+		}
+		fires := plan.misfireTimes(missed, now)
+		log.Warnf("the plan: %s missed %d runs since %v, policy: %q fire %d runs", id, len(missed), fireTime, plan.MisfirePolicy, len(fires))
+		for _, scheduleTime := range fires {
+			if deferTime, reason := sch.checkCalendar(plan, scheduleTime); !deferTime.IsZero() || len(reason) > 0 {
+				log.Warnf("the plan: %s skip the missed time: %v excluded by the calendar: %s", id, scheduleTime, plan.Calendar)
+				continue
+			}
+			if plan.exhausted() {
+				break
+			}
+			log.Infof("schedule execute the missed plan: %s for time: %v", id, scheduleTime)
+			fired = append(fired, &scheduleFire{snapshot: sch.newSnapshot(plan, scheduleTime, now)})
+			plan.Runs++
+		}
+		sch.recordFire(plan, missed[len(missed)-1])
+		if plan.exhausted() {
+			sch.planQueue.remove(plan)
+			sch.finishPlan(plan)
+		}
+	}
+}
+
+// the latest schedule times after the fire time and before now, at most misfireScanLimit,
+// the scan jumps over the older times which never fire when the job missed more runs than the limit
+func (plan *SchedulePlan) missedTimes(fireTime, now time.Time) (missed []time.Time) {
+	scheduleTime := plan.next(fireTime)
+	for !scheduleTime.IsZero() && scheduleTime.Before(now) {
+		if len(missed) == misfireScanLimit {
+			span := missed[len(missed)-1].Sub(missed[0])
+			missed = missed[1:]
+			if now.Sub(scheduleTime) > span {
+				if jumpTime := plan.next(now.Add(-span)); !jumpTime.IsZero() && jumpTime.Before(now) {
+					scheduleTime = jumpTime
+				}
+			}
+		}
+		missed = append(missed, scheduleTime)
+		scheduleTime = plan.next(scheduleTime)
+	}
+	return
+}
+
+// the missed schedule times to fire by the misfire policy of the plan
+func (plan *SchedulePlan) misfireTimes(missed []time.Time, now time.Time) (fires []time.Time) {
+	grace := now.Add(-time.Duration(plan.MisfireGrace) * time.Second)
+	var expired []time.Time
+	for _, scheduleTime := range missed {
+		if plan.MisfireGrace > 0 && !scheduleTime.Before(grace) {
+			fires = append(fires, scheduleTime)
+			continue
+		}
+		expired = append(expired, scheduleTime)
+	}
+	if len(expired) == 0 {
+		return
+	}
+	switch plan.MisfirePolicy {
+	case MisfireFireOnce:
+		fires = append([]time.Time{expired[len(expired)-1]}, fires...)
+	case MisfireFireAll:
+		limit := plan.MisfireMaxRuns
+		if limit <= 0 || limit > MisfireFireAllLimit {
+			limit = MisfireFireAllLimit
+		}
+		if len(expired) > limit {
+			expired = expired[len(expired)-limit:]
+		}
+		fires = append(expired, fires...)
+	}
+	return
+}
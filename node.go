@@ -35,6 +35,12 @@ type JobNode struct {
 	exec         *JobExecutor
 	collection   *JobCollection
 	failOver     *JobSnapshotFailOver
+	timeout      *JobTimeoutChecker
+	workflow     *JobWorkflow
+	calendars    *JobCalendars
+	backfiller   *JobBackfiller
+	limiter      *JobLimiter
+	pending      *JobPendingQueue
 	listeners    []NodeStateChangeListener
 	close        chan bool
 
@@ -73,6 +79,12 @@ func NewJobNode(id string, etcd *etcd.Etcd, dsn string) (node *JobNode, err erro
 	}
 	node.failOver = NewJobSnapshotFailOver(node)
 	node.collection = NewJobCollection(node)
+	node.timeout = NewJobTimeoutChecker(node)
+	node.workflow = NewJobWorkflow(node)
+	node.calendars = NewJobCalendars(node)
+	node.backfiller = NewJobBackfiller(node)
+	node.limiter = NewJobLimiter(node)
+	node.pending = NewJobPendingQueue(node)
 	node.initNode()
 
 	// create job executor
@@ -181,7 +193,7 @@ func (node *JobNode) StartAPIServer(auth *APIAuth, address string, opts ...engin
 }
 
 func (node *JobNode) addListeners() {
-	node.listeners = append(node.listeners, node.scheduler)
+	node.listeners = append(node.listeners, node.limiter, node.scheduler, node.timeout, node.backfiller, node.pending, node.groupManager)
 }
 
 func (node *JobNode) changeState(state int) {
@@ -229,6 +241,7 @@ func (node *JobNode) initNode() {
 func (node *JobNode) Bootstrap() {
 	go node.groupManager.loopLoadGroups()
 	go node.manager.loopLoadJobConf()
+	go node.manager.loopLoadDelayedTask()
 	<-node.close
 }
 
@@ -0,0 +1,116 @@
+package forest
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// the params of the job is a text/template rendered by the leader when build the snapshot, such as:
+// {{.ScheduledTime | date "20060102"}}, {{.SnapshotId}}, {{.Attempt}}, {{.Vars.region}}
+
+// ParamsTemplateData the data of the params template
+type ParamsTemplateData struct {
+	ScheduledTime time.Time         // the logical execution time
+	SnapshotId    string            //
+	Attempt       int               // the retry attempt, 0 means the first execution
+	JobId         string            //
+	JobName       string            //
+	Group         string            //
+	Target        string            //
+	Vars          map[string]string // the variables of the group overridden by the variables of the job
+}
+
+var paramsTemplateFuncs = template.FuncMap{
+	// {{.ScheduledTime | date "2006-01-02"}}
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	// {{.ScheduledTime | timeAdd "-24h" | date "20060102"}}
+	"timeAdd": func(duration string, t time.Time) (time.Time, error) {
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return t, err
+		}
+		return t.Add(d), nil
+	},
+}
+
+// check the params is a template
+func isParamsTemplate(params string) bool {
+	return strings.Contains(params, "{{")
+}
+
+// render the params template with the data
+func renderParams(params string, data *ParamsTemplateData) (string, error) {
+	if !isParamsTemplate(params) {
+		return params, nil
+	}
+	t, err := template.New("params").Funcs(paramsTemplateFuncs).Option("missingkey=zero").Parse(params)
+	if err != nil {
+		return params, err
+	}
+	buf := new(bytes.Buffer)
+	if err = t.Execute(buf, data); err != nil {
+		return params, err
+	}
+	return buf.String(), nil
+}
+
+// check the syntax of the params template by rendering it with the sample data
+func checkParamsTemplate(params string) error {
+	_, err := renderParams(params, &ParamsTemplateData{ScheduledTime: time.Now(), Vars: map[string]string{}})
+	return err
+}
+
+// merge the variables of the group and the job
+func (manager *JobManager) paramsVars(group string, jobVars map[string]string) map[string]string {
+	vars := make(map[string]string, len(jobVars))
+	if manager.node.groupManager != nil {
+		if groupConf := manager.node.groupManager.groupConf(group); groupConf != nil {
+			for k, v := range groupConf.Vars {
+				vars[k] = v
+			}
+		}
+	}
+	for k, v := range jobVars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// new a job snapshot of the plan with the params rendered at the schedule time
+func (sch *JobScheduler) newSnapshot(plan *SchedulePlan, scheduleTime time.Time, now time.Time) *JobSnapshot {
+	snapshot := plan.newSnapshot(now)
+	if plan.delayed == nil {
+		sch.node.manager.renderSnapshot(snapshot, plan.Vars, scheduleTime)
+	}
+	return snapshot
+}
+
+// set the logical time of the snapshot and render the params template at it,
+// the params are sent verbatim if failed to render
+func (manager *JobManager) renderSnapshot(snapshot *JobSnapshot, jobVars map[string]string, logicalTime time.Time) {
+	snapshot.LogicalTime = ToDateString(logicalTime)
+	if !isParamsTemplate(snapshot.Params) {
+		return
+	}
+	params, err := renderParams(snapshot.Params, &ParamsTemplateData{
+		ScheduledTime: logicalTime,
+		SnapshotId:    snapshot.Id,
+		Attempt:       snapshot.Attempt,
+		JobId:         snapshot.JobId,
+		JobName:       snapshot.Name,
+		Group:         snapshot.Group,
+		Target:        snapshot.Target,
+		Vars:          manager.paramsVars(snapshot.Group, jobVars),
+	})
+	if err != nil {
+		log.Warnf("the snapshot: %s render the params: %s error: %v", snapshot.Id, snapshot.Params, err)
+		return
+	}
+	snapshot.Params = params
+}
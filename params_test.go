@@ -0,0 +1,31 @@
+package forest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderParams(t *testing.T) {
+	data := &ParamsTemplateData{
+		ScheduledTime: time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC),
+		SnapshotId:    "s1",
+		Attempt:       2,
+		Vars:          map[string]string{"region": "cn"},
+	}
+	params, err := renderParams(`{"date":"{{.ScheduledTime | timeAdd "-24h" | date "20060102"}}","id":"{{.SnapshotId}}","attempt":{{.Attempt}},"region":"{{.Vars.region}}{{.Vars.none}}"}`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"date":"20240229","id":"s1","attempt":2,"region":"cn"}`; params != expected {
+		t.Fatalf("the params: %s, expected: %s", params, expected)
+	}
+	if params, err = renderParams(`{"a":1}`, data); err != nil || params != `{"a":1}` {
+		t.Fatalf("the plain params changed: %s, %v", params, err)
+	}
+	if err = checkParamsTemplate(`{{.ScheduledTime | date}}`); err == nil {
+		t.Fatal("the invalid template not detected")
+	}
+	if err = checkParamsTemplate(`{{.Unknown}}`); err == nil {
+		t.Fatal("the unknown field not detected")
+	}
+}
@@ -0,0 +1,207 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// the snapshots of the group which has no live client are pending in etcd, the leader dispatch them
+// as soon as a client registered and record the ones waited longer than the max wait as no client
+
+const (
+	JobPendingPath = "/forest/server/pending/" // + group/snapshot.id
+)
+
+// PendingMaxWait the default max duration the snapshot wait for a client
+var PendingMaxWait = time.Hour
+
+// ErrNoClient the group has no client to select
+var ErrNoClient = errors.New("has no client to select")
+
+// errSnapshotPending the snapshot is put into the pending area
+var errSnapshotPending = errors.New("the snapshot is put into the pending area")
+
+// JobPendingQueue the snapshots waiting for the clients of the group
+type JobPendingQueue struct {
+	node *JobNode
+	lk   *sync.Mutex
+}
+
+func NewJobPendingQueue(node *JobNode) (pending *JobPendingQueue) {
+	pending = &JobPendingQueue{
+		node: node,
+		lk:   &sync.Mutex{},
+	}
+	go pending.loop()
+	return
+}
+
+// the max wait of the snapshot
+func pendingMaxWait(conf *JobConf) time.Duration {
+	if conf != nil && conf.MaxWait > 0 {
+		return time.Duration(conf.MaxWait) * time.Second
+	}
+	return PendingMaxWait
+}
+
+// check the snapshot has waited longer than the max wait since created
+func pendingExpired(snapshot *JobSnapshot, conf *JobConf, now time.Time) bool {
+	createTime, err := time.ParseInLocation("2006-01-02 15:04:05", snapshot.CreateTime, time.Local)
+	if err != nil {
+		return false
+	}
+	return now.Sub(createTime) > pendingMaxWait(conf)
+}
+
+// put the snapshot into the pending area of the group
+func (pending *JobPendingQueue) put(snapshot *JobSnapshot) error {
+	value, err := PackJobSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("pack the pending snapshot: %s error: %w", snapshot.Id, err)
+	}
+	if err = pending.node.etcd.Put(JobPendingPath+snapshot.Group+"/"+snapshot.Id, string(value)); err != nil {
+		return fmt.Errorf("put the pending snapshot: %s error: %w", snapshot.Id, err)
+	}
+	log.Warnf("the group: %s has no client, the snapshot: %s of the job: %s is pending", snapshot.Group, snapshot.Id, snapshot.JobId)
+	return errSnapshotPending
+}
+
+// expire the snapshot waited too long and delete it from the pending area after recorded
+func (pending *JobPendingQueue) expire(key string, snapshot *JobSnapshot) {
+	log.Warnf("the snapshot: %s of the job: %s has waited for the client of the group: %s too long", snapshot.Id, snapshot.JobId, snapshot.Group)
+	pending.node.collection.recordJobSnapshot(snapshot, JobExecuteSnapshotNoClientStatus, "等待任务集群的客户端超时")
+	if err := pending.node.etcd.Delete(key); err != nil {
+		log.Errorf("delete the pending snapshot: %s error: %v", snapshot.Id, err)
+	}
+}
+
+// check the pending snapshot left the pending area and the next one can be dispatched,
+// the snapshot waiting for the limiter or failed is not pending any more
+func drainNext(err error) bool {
+	return !errors.Is(err, errSnapshotPending)
+}
+
+// dispatch the pending snapshots of the group in order, the key is deleted only after the snapshot
+// left the pending area, stop when the snapshot is pending again as the group has no client
+func (pending *JobPendingQueue) drain(group string) {
+	if pending.node.state != NodeLeaderState {
+		return
+	}
+	pending.lk.Lock()
+	defer pending.lk.Unlock()
+	keys, values, err := pending.node.etcd.GetWithPrefixKey(JobPendingPath + group + "/")
+	if err != nil {
+		log.Errorf("load the pending snapshots of the group: %s error: %v", group, err)
+		return
+	}
+	now := time.Now()
+	for index, key := range keys {
+		snapshot, err := UnpackJobSnapshot(values[index])
+		if err != nil {
+			log.Errorf("unpack the pending snapshot: %s error: %v", key, err)
+			_ = pending.node.etcd.Delete(string(key))
+			continue
+		}
+		if pendingExpired(snapshot, pending.node.exec.loadJobConf(snapshot), now) {
+			pending.expire(string(key), snapshot)
+			continue
+		}
+		err = pending.node.exec.handleJobSnapshot(snapshot)
+		if !drainNext(err) {
+			// still no client, the key is rewritten in place
+			return
+		}
+		if err := pending.node.etcd.Delete(string(key)); err != nil {
+			log.Errorf("delete the pending snapshot: %s error: %v", snapshot.Id, err)
+		}
+		if err != nil && !isSnapshotParked(err) {
+			log.Error(err)
+		}
+	}
+}
+
+// drain the pending snapshots of all the groups when the node become the leader
+func (pending *JobPendingQueue) notify(state int) {
+	if state != NodeLeaderState {
+		return
+	}
+	for _, group := range pending.groups() {
+		go pending.drain(group)
+	}
+}
+
+// the groups which have the pending snapshots
+func (pending *JobPendingQueue) groups() (groups []string) {
+	keys, _, err := pending.node.etcd.GetWithPrefixKey(JobPendingPath)
+	if err != nil {
+		log.Errorf("load the pending snapshots error: %v", err)
+		return
+	}
+	exists := make(map[string]bool)
+	for _, key := range keys {
+		if group := strings.SplitN(strings.TrimPrefix(string(key), JobPendingPath), "/", 2)[0]; !exists[group] {
+			exists[group] = true
+			groups = append(groups, group)
+		}
+	}
+	return
+}
+
+// expire the pending snapshots waited longer than the max wait on the leader
+func (pending *JobPendingQueue) loop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if pending.node.state != NodeLeaderState {
+			continue
+		}
+		pending.expireAll(now)
+	}
+}
+
+func (pending *JobPendingQueue) expireAll(now time.Time) {
+	pending.lk.Lock()
+	defer pending.lk.Unlock()
+	keys, values, err := pending.node.etcd.GetWithPrefixKey(JobPendingPath)
+	if err != nil {
+		log.Errorf("load the pending snapshots error: %v", err)
+		return
+	}
+	for index, key := range keys {
+		snapshot, err := UnpackJobSnapshot(values[index])
+		if err != nil {
+			continue
+		}
+		if !pendingExpired(snapshot, pending.node.exec.loadJobConf(snapshot), now) {
+			continue
+		}
+		pending.expire(string(key), snapshot)
+	}
+}
+
+// PendingSnapshotList the snapshots of the group waiting for the clients
+func (manager *JobManager) PendingSnapshotList(group string) (snapshots []*JobSnapshot, err error) {
+	var values [][]byte
+	prefix := JobPendingPath
+	if len(group) > 0 {
+		prefix += group + "/"
+	}
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(prefix); err != nil {
+		return
+	}
+	snapshots = make([]*JobSnapshot, 0, len(values))
+	for _, value := range values {
+		snapshot, err := UnpackJobSnapshot(value)
+		if err != nil {
+			log.Errorf("unpack the pending snapshot error: %#v", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return
+}
@@ -0,0 +1,34 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPendingExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	snapshot := &JobSnapshot{CreateTime: "2024-01-01 11:30:00"}
+	if pendingExpired(snapshot, nil, now) {
+		t.Fatal("the default max wait is not reached")
+	}
+	if !pendingExpired(snapshot, &JobConf{MaxWait: 600}, now) {
+		t.Fatal("the max wait of the job is reached")
+	}
+	if pendingExpired(&JobSnapshot{CreateTime: "invalid"}, &JobConf{MaxWait: 1}, now) {
+		t.Fatal("the snapshot with the invalid create time should not expire")
+	}
+}
+
+func TestDrainNext(t *testing.T) {
+	if !drainNext(nil) || !drainNext(errors.New("dispatch error")) {
+		t.Fatal("the dispatched or failed snapshot left the pending area")
+	}
+	if !drainNext(fmt.Errorf("%w: %w", errSnapshotWaiting, errJobLimit)) {
+		t.Fatal("the snapshot waiting for the limiter should not stop the drain")
+	}
+	if drainNext(errSnapshotPending) {
+		t.Fatal("the snapshot pending again should stop the drain")
+	}
+}
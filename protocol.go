@@ -11,6 +11,9 @@ const (
 	JobCreateChangeEvent = iota
 	JobUpdateChangeEvent
 	JobDeleteChangeEvent
+	DelayedTaskPutChangeEvent
+	DelayedTaskDeleteChangeEvent
+	JobExecuteFinishedChangeEvent
 )
 
 const (
@@ -24,12 +27,95 @@ const (
 )
 
 const (
-	JobExecuteSnapshotDoingStatus   = 1
-	JobExecuteSnapshotSuccessStatus = 2
-	JobExecuteSnapshotUnknownStatus = 3
-	JobExecuteSnapshotErrorStatus   = -1
+	JobExecuteSnapshotDoingStatus    = 1
+	JobExecuteSnapshotSuccessStatus  = 2
+	JobExecuteSnapshotUnknownStatus  = 3
+	JobExecuteSnapshotSkippedStatus  = 5
+	JobExecuteSnapshotTimeoutStatus  = 6
+	JobExecuteSnapshotDroppedStatus  = 7
+	JobExecuteSnapshotNoClientStatus = 8
+	JobExecuteSnapshotErrorStatus    = -1
 )
 
+const (
+	ScheduleCron       = "cron"
+	ScheduleFixedRate  = "fixed-rate"
+	ScheduleFixedDelay = "fixed-delay"
+)
+
+const (
+	JitterRandom = "random"
+	JitterHash   = "hash"
+)
+
+const (
+	CalendarSkip  = "skip"
+	CalendarDefer = "defer"
+)
+
+const (
+	MisfireSkip     = "skip"
+	MisfireFireOnce = "fire-once"
+	MisfireFireAll  = "fire-all"
+)
+
+const (
+	ConcurrencyAllow   = "allow"
+	ConcurrencyForbid  = "forbid"
+	ConcurrencyReplace = "replace"
+)
+
+const (
+	JobModeBroadcast = "broadcast"
+	JobModeSharding  = "sharding"
+)
+
+const (
+	RetryBackoffFixed       = "fixed"
+	RetryBackoffExponential = "exponential"
+)
+
+const (
+	WorkflowEdgeSuccess = "success"
+	WorkflowEdgeFailure = "failure"
+	WorkflowEdgeAlways  = "always"
+)
+
+const (
+	BackfillDoingStatus    = 1
+	BackfillFinishedStatus = 2
+	BackfillCanceledStatus = 4
+)
+
+const (
+	WorkflowInstanceDoingStatus    = 1
+	WorkflowInstanceSuccessStatus  = 2
+	WorkflowInstanceCanceledStatus = 4
+	WorkflowInstanceFailureStatus  = -1
+)
+
+const (
+	WorkflowNodeWaitingStatus  = 0
+	WorkflowNodeDoingStatus    = 1
+	WorkflowNodeSuccessStatus  = 2
+	WorkflowNodeCanceledStatus = 4
+	WorkflowNodeSkippedStatus  = 5
+	WorkflowNodeFailureStatus  = -1
+)
+
+// IsFinishedStatus check the execute snapshot status is finished
+func IsFinishedStatus(status int) bool {
+	switch status {
+	case JobExecuteSnapshotSuccessStatus,
+		JobExecuteSnapshotUnknownStatus,
+		JobExecuteSnapshotErrorStatus,
+		JobExecuteSnapshotTimeoutStatus:
+		return true
+	default:
+		return false
+	}
+}
+
 type JobClientDeleteEvent struct {
 	Client *Client
 	Group  *Group
@@ -37,16 +123,71 @@ type JobClientDeleteEvent struct {
 
 // JobConf job
 type JobConf struct {
-	Id      string `json:"id"`
-	Name    string `json:"name"`
-	Group   string `json:"group"`
-	Cron    string `json:"cron"`
-	Status  int    `json:"status"`
-	Target  string `json:"target"`
-	Params  string `json:"params"`
-	Mobile  string `json:"mobile"`
-	Remark  string `json:"remark"`
-	Version int    `json:"version"`
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Group    string `json:"group"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"` // IANA time zone name of the cron, empty means the local time zone
+	Status   int    `json:"status"`
+	Target   string `json:"target"`
+	Params   string `json:"params"`
+	Mobile   string `json:"mobile"`
+	Remark   string `json:"remark"`
+	Version  int    `json:"version"`
+
+	CronVersion int `json:"cronVersion"` // the semantics of the cron saved with, 0 means the legacy `second minute hour day-of-month month` of the 5 fields
+
+	ScheduleType string `json:"scheduleType"` // cron, fixed-rate, fixed-delay, empty means cron
+	Interval     int    `json:"interval"`     // seconds, the interval of the fixed-rate and fixed-delay schedule
+
+	// misfire
+	MisfirePolicy  string `json:"misfirePolicy"`  // skip, fire-once, fire-all
+	MisfireMaxRuns int    `json:"misfireMaxRuns"` // the max runs of the fire-all policy
+	MisfireGrace   int    `json:"misfireGrace"`   // seconds, the missed run within the grace window always fire
+
+	Concurrency string `json:"concurrency"` // allow, forbid, replace: the policy when the previous execution is still doing
+
+	// retry
+	RetryMax       int    `json:"retryMax"`       // the max automatic retry attempts, 0 means never retry
+	RetryBackoff   string `json:"retryBackoff"`   // fixed, exponential
+	RetryInterval  int    `json:"retryInterval"`  // seconds, the delay of the first retry
+	RetryOnUnknown bool   `json:"retryOnUnknown"` // retry the unknown status as well as the error status
+
+	Timeout int `json:"timeout"` // seconds, kill the execution when exceed, 0 means no timeout
+
+	Affinity      bool   `json:"affinity"`      // keep landing on the same client by the consistent hash of the job id
+	LabelSelector string `json:"labelSelector"` // only run on the clients match the labels, such as: gpu=false,zone=a
+
+	// mode
+	Mode        string   `json:"mode"`        // empty means run on one client, broadcast, sharding
+	ShardTotal  int      `json:"shardTotal"`  // the shards of the sharding mode
+	ShardParams []string `json:"shardParams"` // the params of each shard, use the params of the job if not set
+
+	// chaining
+	OnSuccess []string `json:"onSuccess"` // the downstream job ids fire when the job succeeded
+	OnFailure []string `json:"onFailure"` // the downstream job ids fire when the job failed
+
+	// calendar
+	Calendar       string `json:"calendar"`       // the name of the calendar excludes the fire times
+	CalendarPolicy string `json:"calendarPolicy"` // skip, defer: the policy of the fire time excluded by the calendar
+
+	// active window
+	StartAt    string `json:"startAt"`    // 2006-01-02 15:04:05 in the time zone of the job, never fire before it
+	EndAt      string `json:"endAt"`      // 2006-01-02 15:04:05 in the time zone of the job, stop the job after it
+	MaxRuns    int    `json:"maxRuns"`    // stop the job after the runs, 0 means unlimited
+	StopReason string `json:"stopReason"` // the reason the job stopped automatically
+
+	// jitter
+	Jitter     int    `json:"jitter"`     // seconds, the max delay added to the fire time, 0 means use the jitter of the group
+	JitterMode string `json:"jitterMode"` // random, hash: the random delay on every fire or the fixed delay by the hash of the job id
+
+	Vars map[string]string `json:"vars"` // the variables of the params template, override the variables of the group
+
+	Priority int `json:"priority"` // the higher priority dispatch first when the dispatch queue is busy
+
+	MaxConcurrent int `json:"maxConcurrent"` // the max in-flight executions of the job, 0 means unlimited
+
+	MaxWait int `json:"maxWait"` // seconds, the max duration to wait for a client when the group has no client, 0 means the default
 }
 
 type Result struct {
@@ -56,13 +197,35 @@ type Result struct {
 }
 
 type GroupConf struct {
-	Name   string `json:"name"`
-	Remark string `json:"remark"`
+	Name     string `json:"name"`
+	Remark   string `json:"remark"`
+	Selector string `json:"selector"` // random, round-robin, weighted, least-loaded
+	Affinity bool   `json:"affinity"` // select the client by the consistent hash of the job id
+
+	// the default jitter of the jobs in the group which not set the jitter
+	Jitter     int    `json:"jitter"`     // seconds, the max delay added to the fire time
+	JitterMode string `json:"jitterMode"` // random, hash, empty means random
+
+	Vars map[string]string `json:"vars"` // the variables of the params template of the jobs in the group
+
+	MaxConcurrent int `json:"maxConcurrent"` // the max in-flight executions of the group, 0 means unlimited
+}
+
+// ClientMeta the metadata published by the client in its registration value,
+// the plain value is the client name
+type ClientMeta struct {
+	Name     string            `json:"name"`
+	Weight   int               `json:"weight"`
+	Labels   map[string]string `json:"labels"`
+	Version  string            `json:"version"`
+	Capacity int               `json:"capacity"` // the max snapshots run at a time, 0 means unlimited
+	Running  int               `json:"running"`  // the snapshots running on the client
 }
 
 type JobChangeEvent struct {
 	Type int
 	Conf *JobConf
+	Task *DelayedTask
 }
 
 type SchedulePlan struct {
@@ -70,15 +233,175 @@ type SchedulePlan struct {
 	Name       string `json:"name"`
 	Group      string `json:"group"`
 	Cron       string `json:"cron"`
+	Timezone   string `json:"timezone"`
 	Status     int    `json:"status"`
 	Target     string `json:"target"`
 	Params     string `json:"params"`
 	Mobile     string `json:"mobile"`
 	Remark     string `json:"remark"`
 	schedule   cron.Schedule
+	location   *time.Location
 	NextTime   time.Time `json:"nextTime"`
 	BeforeTime time.Time `json:"beforeTime"`
 	Version    int       `json:"version"`
+
+	MisfirePolicy  string `json:"misfirePolicy"`
+	MisfireMaxRuns int    `json:"misfireMaxRuns"`
+	MisfireGrace   int    `json:"misfireGrace"`
+
+	ScheduleType string `json:"scheduleType"`
+	Interval     int    `json:"interval"`
+	Waiting      bool   `json:"waiting"` // the fixed-delay plan is waiting for the previous execution finished
+	timeout      int
+	waitDeadline time.Time // resume the waiting plan after the deadline if the finish of the execution is lost
+
+	Calendar       string `json:"calendar"`
+	CalendarPolicy string `json:"calendarPolicy"`
+
+	StartAt string `json:"startAt"`
+	EndAt   string `json:"endAt"`
+	MaxRuns int    `json:"maxRuns"`
+	Runs    int    `json:"runs"`
+	startAt time.Time
+	endAt   time.Time
+
+	Jitter       int    `json:"jitter"`
+	JitterMode   string `json:"jitterMode"`
+	JitterOffset int    `json:"jitterOffset"` // seconds, the jitter added to the next time
+
+	Vars map[string]string `json:"vars"`
+
+	Priority int `json:"priority"`
+
+	delayed *DelayedTask
+	index   int
+}
+
+// next the next schedule time after now in the time zone of the plan,
+// the zero time if the plan is out of the active window or exhausted the runs
+func (plan *SchedulePlan) next(now time.Time) time.Time {
+	if plan.exhausted() {
+		return time.Time{}
+	}
+	if !plan.startAt.IsZero() && now.Before(plan.startAt) {
+		now = plan.startAt.Add(-time.Second)
+	}
+	if plan.location != nil {
+		now = now.In(plan.location)
+	}
+	next := plan.schedule.Next(now)
+	if !plan.endAt.IsZero() && next.After(plan.endAt) {
+		return time.Time{}
+	}
+	return next
+}
+
+// new a job snapshot of the plan
+func (plan *SchedulePlan) newSnapshot(now time.Time) *JobSnapshot {
+	if plan.delayed != nil {
+		return plan.delayed.newSnapshot(now)
+	}
+	return &JobSnapshot{
+		Id:         GenerateSerialNo() + plan.Id,
+		JobId:      plan.Id,
+		Name:       plan.Name,
+		Group:      plan.Group,
+		Cron:       plan.Cron,
+		Target:     plan.Target,
+		Params:     plan.Params,
+		Remark:     plan.Remark,
+		CreateTime: ToDateString(now),
+		Priority:   plan.Priority,
+	}
+}
+
+// Calendar the business calendar excludes the holidays, the times out of the windows and the blackouts
+type Calendar struct {
+	Name      string              `json:"name"`
+	Timezone  string              `json:"timezone"` // IANA time zone name, empty means the local time zone
+	Holidays  []string            `json:"holidays"` // 2006-01-02
+	Windows   []*CalendarWindow   `json:"windows"`  // the allowed windows, empty means all the time allowed
+	Blackouts []*CalendarBlackout `json:"blackouts"`
+	Remark    string              `json:"remark"`
+}
+
+// CalendarWindow the allowed time window of the weekdays, the end before the start means crossing midnight
+type CalendarWindow struct {
+	Weekdays []int  `json:"weekdays"` // 0-6, Sunday is 0, empty means every day
+	Start    string `json:"start"`    // 15:04
+	End      string `json:"end"`      // 15:04
+}
+
+// CalendarBlackout the excluded period
+type CalendarBlackout struct {
+	Start string `json:"start"` // 2006-01-02 15:04:05
+	End   string `json:"end"`   // 2006-01-02 15:04:05
+}
+
+// Backfill run the job for each schedule time between the start time and the end time
+type Backfill struct {
+	Id         string `json:"id"`
+	JobId      string `json:"jobId"`
+	StartTime  string `json:"startTime"` // 2006-01-02 15:04:05 in the time zone of the job
+	EndTime    string `json:"endTime"`   // 2006-01-02 15:04:05 in the time zone of the job
+	Interval   int    `json:"interval"`  // seconds, the min interval between two dispatches, 0 means 1 second
+	Status     int    `json:"status"`
+	Total      int    `json:"total"`
+	Done       int    `json:"done"`       // the finished logical times
+	LastTime   string `json:"lastTime"`   // the last dispatched logical time
+	SnapshotId string `json:"snapshotId"` // the snapshot of the last dispatched logical time, cleared after it finished
+	CreateTime string `json:"createTime"`
+	FinishTime string `json:"finishTime"`
+}
+
+// Cordon the client stop receiving the new snapshots
+type Cordon struct {
+	Group      string `json:"group"`
+	Client     string `json:"client"` // the client name
+	Reason     string `json:"reason"`
+	Drain      bool   `json:"drain"` // move the snapshots not started yet to the other clients, cleared by the leader after moved
+	CreateTime string `json:"createTime"`
+}
+
+// DelayedTask a temporary task run once at the fire time
+type DelayedTask struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Group      string `json:"group"`
+	Target     string `json:"target"`
+	Params     string `json:"params"`
+	Remark     string `json:"remark"`
+	FireTime   string `json:"fireTime"`        // 2006-01-02 15:04:05 in the local time zone
+	Delay      int    `json:"delay,omitempty"` // seconds, fire after the delay when the fire time is empty
+	CreateTime string `json:"createTime"`
+
+	Snapshot *JobSnapshot `json:"snapshot,omitempty"` // the retry attempt of the job, dispatched as is
+	Fired    bool         `json:"fired,omitempty"`    // the task is fired, deleted after the snapshot dispatched
+}
+
+// new a job snapshot of the delayed task, the snapshot id is the task id
+func (task *DelayedTask) newSnapshot(now time.Time) *JobSnapshot {
+	if task.Snapshot != nil {
+		snapshot := *task.Snapshot
+		snapshot.CreateTime = ToDateString(now)
+		return &snapshot
+	}
+	return &JobSnapshot{
+		Id:         task.Id,
+		Name:       task.Name,
+		Group:      task.Group,
+		Target:     task.Target,
+		Params:     task.Params,
+		Remark:     task.Remark,
+		CreateTime: ToDateString(now),
+	}
+}
+
+// JobFireState the last fire state of the job persisted by the leader
+type JobFireState struct {
+	JobId    string `json:"jobId"`
+	FireTime string `json:"fireTime"` // RFC3339
+	Runs     int    `json:"runs"`     // the run count of the job
 }
 
 type JobSnapshotWithPath struct {
@@ -97,20 +420,58 @@ type JobSnapshot struct {
 	Params     string `json:"params"`
 	Remark     string `json:"remark"`
 	CreateTime string `json:"createTime"`
+	Attempt    int    `json:"attempt"` // the retry attempt, 0 means the first execution
+	RetryOf    string `json:"retryOf"` // the snapshot id of the previous attempt
+	Timeout    int    `json:"timeout"` // seconds
+
+	ShardParentId string `json:"shardParentId"` // the snapshot id of the parent of the shard
+	ShardIndex    int    `json:"shardIndex"`
+	ShardTotal    int    `json:"shardTotal"`
+
+	// the upstream execution which fired the snapshot by the job chaining
+	UpstreamId     string `json:"upstreamId"`
+	UpstreamStatus int    `json:"upstreamStatus"`
+	UpstreamResult string `json:"upstreamResult"`
+
+	LogicalTime string `json:"logicalTime"` // the logical execution time, the schedule time or the logical time of the backfill
+
+	Priority int `json:"priority"`
 }
 
 func (s *JobSnapshot) Path() string {
 	return fmt.Sprintf(JobClientSnapshotPath, s.Group, s.Ip)
 }
 
+type QueryCronPreviewParam struct {
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+	Count    int    `json:"count"` // the count of the next fire times, 5 by default
+}
+
+type QueryParamsPreviewParam struct {
+	Params        string            `json:"params"`
+	Group         string            `json:"group"`
+	Vars          map[string]string `json:"vars"`
+	Timezone      string            `json:"timezone"`
+	ScheduledTime string            `json:"scheduledTime"` // 2006-01-02 15:04:05 in the time zone, empty means now
+	Attempt       int               `json:"attempt"`
+}
+
 type QueryClientParam struct {
 	Group string `json:"group"`
 }
 
 type JobClient struct {
-	Name  string `json:"name"`
-	Path  string `json:"path"`
-	Group string `json:"group"`
+	Name     string            `json:"name"`
+	Path     string            `json:"path"`
+	Group    string            `json:"group"`
+	Weight   int               `json:"weight"`
+	Labels   map[string]string `json:"labels"`
+	Version  string            `json:"version"`
+	Capacity int               `json:"capacity"`
+	Running  int               `json:"running"`
+	Used     int               `json:"used"` // the used slots of the capacity
+	Cordoned bool              `json:"cordoned"`
 }
 type QuerySnapshotParam struct {
 	Group string `json:"group"`
@@ -140,6 +501,14 @@ type JobExecuteSnapshot struct {
 	Times      int    `json:"times" db:"times"`
 	Status     int    `json:"status" db:"status"`
 	Result     string `json:"result" db:"result"`
+	Attempt    int    `json:"attempt" db:"attempt"`
+	RetryOf    string `json:"retryOf" db:"retry_of"`
+
+	ShardParentId string `json:"shardParentId" db:"shard_parent_id"`
+	ShardIndex    int    `json:"shardIndex" db:"shard_index"` // -1 means the parent of the shards
+	ShardTotal    int    `json:"shardTotal" db:"shard_total"`
+
+	LogicalTime string `json:"logicalTime" db:"logical_time"`
 }
 
 func (s *JobExecuteSnapshot) Path() string {
@@ -157,11 +526,64 @@ func (s *JobExecuteSnapshot) NewSnapshot() *JobSnapshot {
 		Params:     s.Params,
 		Remark:     s.Remark,
 		CreateTime: s.CreateTime,
+		Attempt:    s.Attempt,
+		RetryOf:    s.RetryOf,
+
+		ShardParentId: s.ShardParentId,
+		ShardIndex:    s.ShardIndex,
+		ShardTotal:    s.ShardTotal,
+
+		LogicalTime: s.LogicalTime,
 
 		// Ip: s.Ip, 执行时分配
 	}
 }
 
+// WorkflowConf the workflow, the nodes are the job ids and the edges run the downstream job by the upstream status
+type WorkflowConf struct {
+	Id      string          `json:"id"`
+	Name    string          `json:"name"`
+	Nodes   []string        `json:"nodes"`
+	Edges   []*WorkflowEdge `json:"edges"`
+	Remark  string          `json:"remark"`
+	Version int             `json:"version"`
+}
+
+// WorkflowEdge run the job To when the job From finished with the status On
+type WorkflowEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	On   string `json:"on"` // success, failure, always, empty means success
+}
+
+// WorkflowInstance a run of the workflow
+type WorkflowInstance struct {
+	Id         string `json:"id" db:"id"`
+	WorkflowId string `json:"workflowId" db:"workflow_id"`
+	Name       string `json:"name" db:"name"`
+	Conf       string `json:"conf" db:"conf"` // the workflow conf when the instance created
+	Status     int    `json:"status" db:"status"`
+	CreateTime string `json:"createTime" db:"create_time"`
+	FinishTime string `json:"finishTime" db:"finish_time"`
+}
+
+// WorkflowInstanceNode the state of a job in the workflow instance
+type WorkflowInstanceNode struct {
+	InstanceId string `json:"instanceId" db:"instance_id"`
+	JobId      string `json:"jobId" db:"job_id"`
+	SnapshotId string `json:"snapshotId" db:"snapshot_id"`
+	Status     int    `json:"status" db:"status"`
+	UpdateTime string `json:"updateTime" db:"update_time"`
+}
+
+type QueryWorkflowInstanceParam struct {
+	Id         string `json:"id"`
+	WorkflowId string `json:"workflowId"`
+	Status     int    `json:"status"`
+	PageSize   int    `json:"pageSize"`
+	PageNo     int    `json:"pageNo"`
+}
+
 type QueryExecuteSnapshotParam struct {
 	Group    string `json:"group"`
 	Id       string `json:"id"`
@@ -0,0 +1,83 @@
+package forest
+
+import (
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// RetryBackoffLimit the max delay between two automatic retry attempts
+var RetryBackoffLimit = time.Hour
+
+// the delay before the retry attempt
+func (conf *JobConf) retryDelay(attempt int) time.Duration {
+	delay := time.Duration(conf.RetryInterval) * time.Second
+	if conf.RetryBackoff == RetryBackoffExponential {
+		for i := 1; i < attempt && delay < RetryBackoffLimit; i++ {
+			delay *= 2
+		}
+	}
+	if delay > RetryBackoffLimit {
+		delay = RetryBackoffLimit
+	}
+	return delay
+}
+
+// check the execute snapshot status should be retried by the retry policy
+func (conf *JobConf) shouldRetry(status int) bool {
+	switch status {
+	case JobExecuteSnapshotErrorStatus, JobExecuteSnapshotTimeoutStatus:
+		return true
+	case JobExecuteSnapshotUnknownStatus:
+		return conf.RetryOnUnknown
+	default:
+		return false
+	}
+}
+
+// retry the finished execute snapshot by the retry policy of the job, return the retry snapshot if retried,
+// the shards are not retried one by one but the parent of them as a whole
+func (c *JobCollection) tryRetry(snapshot *JobExecuteSnapshot) *JobSnapshot {
+	if len(snapshot.JobId) == 0 || snapshot.isShard() {
+		return nil
+	}
+	conf, err := c.node.manager.GetJob(snapshot.JobId)
+	if err != nil {
+		log.Warnf("the snapshot: %s load the job conf: %s error: %v", snapshot.Id, snapshot.JobId, err)
+		return nil
+	}
+	if conf.RetryMax <= 0 || !conf.shouldRetry(snapshot.Status) {
+		return nil
+	}
+	if snapshot.Attempt >= conf.RetryMax {
+		log.Warnf("the snapshot: %s of the job: %s has exhausted the retry attempts: %d", snapshot.Id, snapshot.JobId, conf.RetryMax)
+		return nil
+	}
+
+	retry := snapshot.NewSnapshot()
+	retry.Id = GenerateSerialNo() + snapshot.JobId
+	retry.CreateTime = ``
+	retry.Attempt = snapshot.Attempt + 1
+	retry.RetryOf = snapshot.Id
+	retry.Priority = conf.Priority
+	if isParamsTemplate(conf.Params) {
+		// render the params again for the attempt at the same logical time
+		location, err := LoadLocation(conf.Timezone)
+		if err != nil {
+			location = time.Local
+		}
+		if logicalTime, err := time.ParseInLocation("2006-01-02 15:04:05", snapshot.LogicalTime, location); err == nil {
+			retry.Params = conf.Params
+			c.node.manager.renderSnapshot(retry, conf.Vars, logicalTime)
+		} else {
+			log.Warnf("the snapshot: %s has no valid logical time: %q, the retry snapshot: %s keep the rendered params", snapshot.Id, snapshot.LogicalTime, retry.Id)
+		}
+	}
+	delay := conf.retryDelay(retry.Attempt)
+	if err = c.node.manager.addRetryTask(retry, time.Now().Add(delay)); err != nil {
+		log.Errorf("the snapshot: %s of the job: %s add the retry snapshot: %s error: %v", snapshot.Id, snapshot.JobId, retry.Id, err)
+		return nil
+	}
+	log.Infof("the snapshot: %s of the job: %s will retry as the snapshot: %s attempt: %d after %v", snapshot.Id, snapshot.JobId, retry.Id, retry.Attempt, delay)
+	return retry
+}
@@ -0,0 +1,76 @@
+package forest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobConfRetryDelay(t *testing.T) {
+	fixed := &JobConf{RetryInterval: 10, RetryBackoff: RetryBackoffFixed}
+	for _, attempt := range []int{1, 2, 5} {
+		if delay := fixed.retryDelay(attempt); delay != 10*time.Second {
+			t.Fatalf("the fixed delay of the attempt: %d is %v", attempt, delay)
+		}
+	}
+	exponential := &JobConf{RetryInterval: 10, RetryBackoff: RetryBackoffExponential}
+	cases := map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 20: RetryBackoffLimit}
+	for attempt, expected := range cases {
+		if delay := exponential.retryDelay(attempt); delay != expected {
+			t.Fatalf("the exponential delay of the attempt: %d is %v, expected: %v", attempt, delay, expected)
+		}
+	}
+	if delay := (&JobConf{RetryInterval: 7200}).retryDelay(1); delay != RetryBackoffLimit {
+		t.Fatalf("the delay should not exceed the limit: %v", delay)
+	}
+}
+
+func TestJobConfShouldRetry(t *testing.T) {
+	conf := &JobConf{}
+	cases := map[int]bool{
+		JobExecuteSnapshotErrorStatus:    true,
+		JobExecuteSnapshotTimeoutStatus:  true,
+		JobExecuteSnapshotUnknownStatus:  false,
+		JobExecuteSnapshotSuccessStatus:  false,
+		JobExecuteSnapshotSkippedStatus:  false,
+		JobExecuteSnapshotDroppedStatus:  false,
+		JobExecuteSnapshotNoClientStatus: false,
+	}
+	for status, expected := range cases {
+		if conf.shouldRetry(status) != expected {
+			t.Fatalf("the status: %d should retry: %v", status, expected)
+		}
+	}
+	conf.RetryOnUnknown = true
+	if !conf.shouldRetry(JobExecuteSnapshotUnknownStatus) {
+		t.Fatal("the unknown status should retry")
+	}
+}
+
+func TestDelayedTaskRetrySnapshot(t *testing.T) {
+	now := time.Now()
+	retry := &JobSnapshot{Id: "r1", JobId: "a", Attempt: 2, Priority: 3}
+	task := &DelayedTask{Id: retry.Id, Snapshot: retry}
+	snapshot := task.newSnapshot(now)
+	if snapshot == retry || snapshot.Attempt != 2 || snapshot.JobId != "a" || snapshot.Priority != 3 {
+		t.Fatalf("the retry snapshot: %#v", snapshot)
+	}
+	if snapshot.CreateTime != ToDateString(now) {
+		t.Fatalf("the create time: %s", snapshot.CreateTime)
+	}
+}
+
+func TestMergeDispatched(t *testing.T) {
+	dispatched := newExecuteSnapshot(&JobSnapshot{Id: "r2", JobId: "a", Attempt: 2, RetryOf: "r1", LogicalTime: "2026-10-01 10:00:00"}, JobExecuteSnapshotDoingStatus, "")
+	// the client not echo the retry fields
+	reported := &JobExecuteSnapshot{Id: "r2", JobId: "a", Ip: "c1", Status: JobExecuteSnapshotErrorStatus, Result: "failed"}
+	reported.mergeDispatched(dispatched)
+	if reported.Attempt != 2 || reported.RetryOf != "r1" {
+		t.Fatalf("the retry fields of the reported execution: %d, %s", reported.Attempt, reported.RetryOf)
+	}
+	if reported.LogicalTime != "2026-10-01 10:00:00" {
+		t.Fatalf("the logical time of the reported execution: %s", reported.LogicalTime)
+	}
+	if reported.Status != JobExecuteSnapshotErrorStatus || reported.Ip != "c1" || reported.Result != "failed" {
+		t.Fatalf("the reported execution: %#v", reported)
+	}
+}
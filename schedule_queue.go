@@ -0,0 +1,63 @@
+package forest
+
+import "container/heap"
+
+// planQueue a min-heap of the schedule plans ordered by the next schedule time,
+// the scheduler only touches the plans on the top which are due
+type planQueue []*SchedulePlan
+
+func (q planQueue) Len() int {
+	return len(q)
+}
+
+func (q planQueue) Less(i, j int) bool {
+	return q[i].NextTime.Before(q[j].NextTime)
+}
+
+func (q planQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *planQueue) Push(x interface{}) {
+	plan := x.(*SchedulePlan)
+	plan.index = len(*q)
+	*q = append(*q, plan)
+}
+
+func (q *planQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	plan := old[n-1]
+	old[n-1] = nil
+	plan.index = -1
+	*q = old[:n-1]
+	return plan
+}
+
+// peek the plan with the least next schedule time
+func (q planQueue) peek() *SchedulePlan {
+	if len(q) == 0 {
+		return nil
+	}
+	return q[0]
+}
+
+// push a plan into the queue
+func (q *planQueue) push(plan *SchedulePlan) {
+	heap.Push(q, plan)
+}
+
+// remove the plan from the queue
+func (q *planQueue) remove(plan *SchedulePlan) {
+	if plan.index < 0 || plan.index >= len(*q) || (*q)[plan.index] != plan {
+		return
+	}
+	heap.Remove(q, plan.index)
+}
+
+// fix the position of the plan after its next schedule time changed
+func (q *planQueue) fix(plan *SchedulePlan) {
+	heap.Fix(q, plan.index)
+}
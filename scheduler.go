@@ -13,8 +13,14 @@ type JobScheduler struct {
 	node          *JobNode
 	eventChan     chan *JobChangeEvent
 	schedulePlans map[string]*SchedulePlan
+	delayedPlans  map[string]*SchedulePlan
+	planQueue     planQueue
 	lk            *sync.RWMutex
 	syncStatus    bool
+	runs          map[string]int // the run counts of the jobs, loaded in bulk when the node become the leader
+	runsLoaded    bool
+	fireStates    map[string]*JobFireState // the fire states to write before the fires dispatched
+	fireLk        *sync.Mutex
 }
 
 func NewJobScheduler(node *JobNode) (sch *JobScheduler) {
@@ -23,8 +29,13 @@ func NewJobScheduler(node *JobNode) (sch *JobScheduler) {
 		node:          node,
 		eventChan:     make(chan *JobChangeEvent, 250),
 		schedulePlans: make(map[string]*SchedulePlan),
+		delayedPlans:  make(map[string]*SchedulePlan),
+		planQueue:     make(planQueue, 0),
 		lk:            &sync.RWMutex{},
 		syncStatus:    false,
+		runs:          make(map[string]int),
+		fireStates:    make(map[string]*JobFireState),
+		fireLk:        &sync.Mutex{},
 	}
 	go sch.loopSchedule()
 	go sch.loopSync()
@@ -43,6 +54,12 @@ func (sch *JobScheduler) handleJobChangeEvent(event *JobChangeEvent) {
 		sch.handleJobUpdateEvent(event)
 	case JobDeleteChangeEvent:
 		sch.handleJobDeleteEvent(event)
+	case DelayedTaskPutChangeEvent:
+		sch.putDelayedPlan(event.Task)
+	case DelayedTaskDeleteChangeEvent:
+		sch.deleteDelayedPlan(event.Task.Id)
+	case JobExecuteFinishedChangeEvent:
+		sch.handleJobExecuteFinishedEvent(event)
 	}
 }
 
@@ -55,10 +72,9 @@ func (sch *JobScheduler) handleJobCreateEvent(event *JobChangeEvent) {
 func (sch *JobScheduler) handleJobUpdateEvent(event *JobChangeEvent) {
 
 	var (
-		err      error
-		schedule cron.Schedule
-		plan     *SchedulePlan
-		ok       bool
+		err  error
+		plan *SchedulePlan
+		ok   bool
 	)
 
 	jobConf := event.Conf
@@ -77,32 +93,20 @@ func (sch *JobScheduler) handleJobUpdateEvent(event *JobChangeEvent) {
 	// stop must delete from the job schedule plan list
 	if jobConf.Status == JobStopStatus {
 		log.Warnf("the job conf: %#v status is stop must delete from the schedule plan", jobConf)
-		delete(sch.schedulePlans, jobConf.Id)
+		sch.deletePlan(jobConf.Id)
 		return
 	}
 
-	if schedule, err = cron.Parse(jobConf.Cron); err != nil {
+	if plan, err = newSchedulePlan(jobConf, time.Now()); err != nil {
 		log.Errorf("the job conf: %#v parse the cron error: %#v", jobConf, err)
 		return
 	}
 
-	// build schedule plan
-	plan = &SchedulePlan{
-		Id:       jobConf.Id,
-		Name:     jobConf.Name,
-		Group:    jobConf.Group,
-		Cron:     jobConf.Cron,
-		Target:   jobConf.Target,
-		Params:   jobConf.Params,
-		Mobile:   jobConf.Mobile,
-		Remark:   jobConf.Remark,
-		schedule: schedule,
-		Version:  jobConf.Version,
-		NextTime: schedule.Next(time.Now()),
-	}
-
 	// update the schedule plan
-	sch.schedulePlans[jobConf.Id] = plan
+	sch.putPlan(plan)
+	if plan.NextTime.IsZero() {
+		sch.finishPlan(plan)
+	}
 	log.Infof("the job conf: %#v update a new schedule plan: %#v", jobConf, plan)
 }
 
@@ -125,15 +129,15 @@ func (sch *JobScheduler) handleJobDeleteEvent(event *JobChangeEvent) {
 		return
 	}
 	log.Warnf("the job conf: %#v delete a schedule plan: %#v", jobConf, plan)
-	delete(sch.schedulePlans, jobConf.Id)
+	sch.deletePlan(jobConf.Id)
 
 }
 
 func (sch *JobScheduler) createJobPlan(event *JobChangeEvent) {
 
 	var (
-		err      error
-		schedule cron.Schedule
+		err  error
+		plan *SchedulePlan
 	)
 
 	jobConf := event.Conf
@@ -148,29 +152,79 @@ func (sch *JobScheduler) createJobPlan(event *JobChangeEvent) {
 		return
 	}
 
-	if schedule, err = cron.Parse(jobConf.Cron); err != nil {
-		log.Errorf("the job conf: %#v cron is error exp", jobConf)
+	if plan, err = newSchedulePlan(jobConf, time.Now()); err != nil {
+		log.Errorf("the job conf: %#v cron is error exp: %v", jobConf, err)
 		return
 	}
 
-	// build schedule plan
-	plan := &SchedulePlan{
+	sch.putPlan(plan)
+	if plan.NextTime.IsZero() {
+		sch.finishPlan(plan)
+	}
+
+	log.Infof("the job conf: %#v create a new schedule plan: %#v", jobConf, plan)
+}
+
+// build a schedule plan for the job conf
+func newSchedulePlan(jobConf *JobConf, now time.Time) (plan *SchedulePlan, err error) {
+	var (
+		schedule cron.Schedule
+		location *time.Location
+		startAt  time.Time
+		endAt    time.Time
+	)
+	if schedule, err = newSchedule(jobConf); err != nil {
+		return
+	}
+	if location, err = LoadLocation(jobConf.Timezone); err != nil {
+		return
+	}
+	if startAt, err = parseJobTime(jobConf.StartAt, location); err != nil {
+		return
+	}
+	if endAt, err = parseJobTime(jobConf.EndAt, location); err != nil {
+		return
+	}
+	plan = &SchedulePlan{
 		Id:       jobConf.Id,
 		Name:     jobConf.Name,
 		Group:    jobConf.Group,
 		Cron:     jobConf.Cron,
+		Timezone: jobConf.Timezone,
 		Target:   jobConf.Target,
 		Params:   jobConf.Params,
 		Mobile:   jobConf.Mobile,
 		Remark:   jobConf.Remark,
 		Version:  jobConf.Version,
 		schedule: schedule,
-		NextTime: schedule.Next(time.Now()),
-	}
+		location: location,
 
-	sch.schedulePlans[jobConf.Id] = plan
+		MisfirePolicy:  jobConf.MisfirePolicy,
+		MisfireMaxRuns: jobConf.MisfireMaxRuns,
+		MisfireGrace:   jobConf.MisfireGrace,
 
-	log.Infof("the job conf: %#v create a new schedule plan: %#v", jobConf, plan)
+		ScheduleType: jobConf.ScheduleType,
+		Interval:     jobConf.Interval,
+		timeout:      jobConf.Timeout,
+
+		Calendar:       jobConf.Calendar,
+		CalendarPolicy: jobConf.CalendarPolicy,
+
+		StartAt: jobConf.StartAt,
+		EndAt:   jobConf.EndAt,
+		MaxRuns: jobConf.MaxRuns,
+		startAt: startAt,
+		endAt:   endAt,
+
+		Jitter:     jobConf.Jitter,
+		JitterMode: jobConf.JitterMode,
+
+		Vars: jobConf.Vars,
+
+		Priority: jobConf.Priority,
+	}
+	plan.NextTime = plan.next(now)
+	return
 }
 
 // push a job change event
@@ -195,54 +249,128 @@ func (sch *JobScheduler) loopSchedule() {
 	}
 }
 
+// put the schedule plan into the plan list and the plan queue
+func (sch *JobScheduler) putPlan(plan *SchedulePlan) {
+	if old, ok := sch.schedulePlans[plan.Id]; ok {
+		sch.planQueue.remove(old)
+	}
+	plan.Runs = sch.runs[plan.Id]
+	sch.schedulePlans[plan.Id] = plan
+	plan.NextTime = sch.jitter(plan, plan.NextTime)
+	if !plan.NextTime.IsZero() {
+		sch.planQueue.push(plan)
+	}
+}
+
+// delete the schedule plan from the plan list and the plan queue
+func (sch *JobScheduler) deletePlan(id string) {
+	plan, ok := sch.schedulePlans[id]
+	if !ok {
+		return
+	}
+	sch.planQueue.remove(plan)
+	delete(sch.schedulePlans, id)
+}
+
+// scheduleFire the snapshot fired by the scheduler, dispatched after the scheduler lock released
+type scheduleFire struct {
+	snapshot *JobSnapshot
+	skip     string       // the reason to skip the snapshot
+	task     *DelayedTask // the fired delayed task
+}
+
 // try schedule the job
 func (sch *JobScheduler) trySchedule() time.Duration {
-	var (
-		first bool
-	)
-	if len(sch.schedulePlans) == 0 {
-		return time.Second
+	sch.lk.Lock()
+	duration, fires := sch.schedule(time.Now())
+	sch.lk.Unlock()
+	sch.dispatch(fires)
+	return duration
+}
+
+// dispatch the fired snapshots after the fire states written, must not hold the lock
+func (sch *JobScheduler) dispatch(fires []*scheduleFire) {
+	sch.writeFireStates()
+	for _, fire := range fires {
+		if len(fire.skip) > 0 {
+			sch.node.collection.recordJobSnapshot(fire.snapshot, JobExecuteSnapshotSkippedStatus, fire.skip)
+			continue
+		}
+		if fire.task != nil {
+			go sch.fireDelayedTask(fire.task, fire.snapshot)
+			continue
+		}
+		sch.node.exec.scheduleSnapshot(fire.snapshot)
 	}
+}
 
-	now := time.Now()
-	leastTime := new(time.Time)
-	first = true
-	for _, plan := range sch.schedulePlans {
+// schedule the due plans on the top of the plan queue, return the duration to the next wake-up
+// and the fired snapshots to dispatch after the lock released
+func (sch *JobScheduler) schedule(now time.Time) (time.Duration, []*scheduleFire) {
+	var fires []*scheduleFire
+	for {
+		plan := sch.planQueue.peek()
+		if plan == nil {
+			return time.Second, fires
+		}
 		scheduleTime := plan.NextTime
-		if scheduleTime.Before(now) && sch.node.state == NodeLeaderState {
-			log.Infof("schedule execute the plan: %#v", plan)
-			snapshot := &JobSnapshot{
-				Id:         GenerateSerialNo() + plan.Id,
-				JobId:      plan.Id,
-				Name:       plan.Name,
-				Group:      plan.Group,
-				Cron:       plan.Cron,
-				Target:     plan.Target,
-				Params:     plan.Params,
-				Remark:     plan.Remark,
-				CreateTime: ToDateString(now),
+		if !scheduleTime.Before(now) {
+			return scheduleTime.Sub(now), fires
+		}
+		if plan.delayed != nil {
+			if fire := sch.fireDelayedPlan(plan, now); fire != nil {
+				fires = append(fires, fire)
+			}
+			continue
+		}
+		deferTime, reason := sch.checkCalendar(plan, scheduleTime)
+		if !deferTime.IsZero() {
+			log.Infof("the plan: %s defer the fire time: %v to %v by the calendar: %s", plan.Id, scheduleTime, deferTime, plan.Calendar)
+			plan.NextTime, plan.JitterOffset = deferTime, 0
+			sch.planQueue.fix(plan)
+			continue
+		}
+		if sch.node.state == NodeLeaderState {
+			if !sch.runsLoaded {
+				// wait for the run counts loaded by the new leader, never block on the etcd here
+				return time.Second, fires
+			}
+			if plan.exhausted() {
+				sch.planQueue.remove(plan)
+				sch.finishPlan(plan)
+				continue
+			}
+			snapshot := sch.newSnapshot(plan, scheduleTime.Add(-time.Duration(plan.JitterOffset)*time.Second), now)
+			if len(reason) > 0 {
+				log.Warnf("the plan: %s skip the fire time: %v, %s", plan.Id, scheduleTime, reason)
+			} else {
+				log.Infof("schedule execute the plan: %#v", plan)
+				plan.Runs++
+			}
+			fires = append(fires, &scheduleFire{snapshot: snapshot, skip: reason})
+			sch.recordFire(plan, scheduleTime)
+			if plan.exhausted() {
+				// the last run fired, stop the job now instead of at the next schedule time
+				plan.BeforeTime = scheduleTime
+				sch.planQueue.remove(plan)
+				sch.finishPlan(plan)
+				continue
 			}
-			sch.node.exec.pushSnapshot(snapshot)
 		}
-		nextTime := plan.schedule.Next(now)
-		plan.NextTime = nextTime
 		plan.BeforeTime = scheduleTime
-
-		// first
-		if first {
-			first = false
-			leastTime = &nextTime
+		if plan.ScheduleType == ScheduleFixedDelay && len(reason) == 0 {
+			sch.waitPlan(plan, now)
+			continue
 		}
-
-		// check least time after next schedule  time
-		if leastTime.After(nextTime) {
-			leastTime = &nextTime
+		plan.NextTime = sch.nextTime(plan, now)
+		if plan.NextTime.IsZero() {
+			log.Warnf("the schedule plan: %#v has no next schedule time", plan)
+			sch.planQueue.remove(plan)
+			sch.finishPlan(plan)
+			continue
 		}
+		sch.planQueue.fix(plan)
 	}
-	if leastTime.Before(now) {
-		return time.Second
-	}
-	return leastTime.Sub(now)
 }
 
 func (sch *JobScheduler) loopSync() {
@@ -252,6 +380,11 @@ func (sch *JobScheduler) loopSync() {
 		select {
 		case <-timer.C:
 			sch.trySync()
+			if sch.node.state == NodeLeaderState && !sch.loaded() {
+				// the new leader failed to load the fire states
+				sch.catchUpMisfires()
+			}
+			sch.checkWaitingPlans(time.Now())
 		}
 		timer.Reset(1 * time.Minute)
 	}
@@ -293,7 +426,7 @@ func (sch *JobScheduler) trySync() {
 	for id, plan := range sch.schedulePlans {
 		if !sch.existPlan(id, jobConfs) {
 			log.Warnf("sync the schedule plan %v must delete", plan)
-			delete(sch.schedulePlans, id)
+			sch.deletePlan(id)
 		}
 	}
 	log.Infof("finish sync the schedule plan use【%dms】....", time.Since(now).Milliseconds())
@@ -339,5 +472,19 @@ func (sch *JobScheduler) notify(state int) {
 	if state == NodeLeaderState {
 		log.Infof("found the job #%v state notify state: %d, must sync the job schedule plan", sch.node.id, state)
 		sch.trySync()
+		sch.catchUpMisfires()
+		sch.resumeFixedDelayPlans()
+		sch.loadDelayedPlans()
+		return
 	}
+	sch.lk.Lock()
+	sch.runsLoaded = false
+	sch.lk.Unlock()
+}
+
+// check the run counts loaded since the node become the leader
+func (sch *JobScheduler) loaded() bool {
+	sch.lk.RLock()
+	defer sch.lk.RUnlock()
+	return sch.runsLoaded
 }
@@ -0,0 +1,298 @@
+package forest
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+func newTestScheduler(n int, now time.Time) *JobScheduler {
+	sch := &JobScheduler{
+		node:          &JobNode{state: NodeFollowerState},
+		schedulePlans: make(map[string]*SchedulePlan),
+		planQueue:     make(planQueue, 0),
+		lk:            &sync.RWMutex{},
+	}
+	for _, plan := range newTestPlans(n, now) {
+		sch.putPlan(plan)
+	}
+	return sch
+}
+
+func newTestPlans(n int, now time.Time) []*SchedulePlan {
+	plans := make([]*SchedulePlan, n)
+	for i := 0; i < n; i++ {
+		schedule := cron.Every(time.Duration(60+i%3600) * time.Second)
+		plans[i] = &SchedulePlan{
+			Id:       strconv.Itoa(i),
+			schedule: schedule,
+			NextTime: schedule.Next(now),
+		}
+	}
+	return plans
+}
+
+// scanSchedule the map scan which walks every plan on every wake-up
+func scanSchedule(plans map[string]*SchedulePlan, now time.Time) time.Duration {
+	var leastTime time.Time
+	for _, plan := range plans {
+		nextTime := plan.schedule.Next(now)
+		plan.BeforeTime = plan.NextTime
+		plan.NextTime = nextTime
+		if leastTime.IsZero() || leastTime.After(nextTime) {
+			leastTime = nextTime
+		}
+	}
+	return leastTime.Sub(now)
+}
+
+func TestScheduleOnlyDuePlans(t *testing.T) {
+	now := time.Now()
+	sch := newTestScheduler(100, now)
+	duration, _ := sch.schedule(now)
+	if duration <= 0 || duration > time.Minute {
+		t.Fatalf("unexpected duration: %v", duration)
+	}
+
+	now = now.Add(90 * time.Second)
+	sch.schedule(now)
+	for _, plan := range sch.schedulePlans {
+		if !plan.NextTime.After(now) {
+			t.Fatalf("the plan: %s is due but not scheduled", plan.Id)
+		}
+	}
+	for i := 1; i < sch.planQueue.Len(); i++ {
+		if sch.planQueue.Less(i, (i-1)/2) {
+			t.Fatalf("the plan queue is not a heap at %d", i)
+		}
+	}
+
+	sch.deletePlan("0")
+	if _, ok := sch.schedulePlans["0"]; ok || sch.planQueue.Len() != 99 {
+		t.Fatalf("the plan was not deleted")
+	}
+}
+
+func benchmarkHeapSchedule(b *testing.B, n int) {
+	now := time.Now()
+	sch := newTestScheduler(n, now)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Second)
+		sch.schedule(now)
+	}
+}
+
+func benchmarkScanSchedule(b *testing.B, n int) {
+	now := time.Now()
+	plans := make(map[string]*SchedulePlan, n)
+	for _, plan := range newTestPlans(n, now) {
+		plans[plan.Id] = plan
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Second)
+		scanSchedule(plans, now)
+	}
+}
+
+func BenchmarkHeapSchedule1000(b *testing.B)  { benchmarkHeapSchedule(b, 1000) }
+func BenchmarkHeapSchedule10000(b *testing.B) { benchmarkHeapSchedule(b, 10000) }
+func BenchmarkHeapSchedule50000(b *testing.B) { benchmarkHeapSchedule(b, 50000) }
+func BenchmarkScanSchedule1000(b *testing.B)  { benchmarkScanSchedule(b, 1000) }
+func BenchmarkScanSchedule10000(b *testing.B) { benchmarkScanSchedule(b, 10000) }
+func BenchmarkScanSchedule50000(b *testing.B) { benchmarkScanSchedule(b, 50000) }
+
+func TestSchedulePlanTimezone(t *testing.T) {
+	now := time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC)
+	for _, timezone := range []string{"Asia/Tokyo", "Europe/Berlin"} {
+		location, err := LoadLocation(timezone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := newSchedulePlan(&JobConf{Id: timezone, Cron: "0 0 2 * * *", Timezone: timezone}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if local := plan.NextTime.In(location); local.Hour() != 2 || local.Minute() != 0 {
+				t.Fatalf("the plan: %s next time: %v is not 02:00", timezone, local)
+			}
+			plan.NextTime = plan.next(plan.NextTime)
+		}
+	}
+	if _, err := newSchedulePlan(&JobConf{Cron: "0 0 2 * * *", Timezone: "Mars/Olympus"}, now); err == nil {
+		t.Fatal("the invalid time zone must be rejected")
+	}
+}
+
+func TestSchedulePlanMisfire(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 30, 0, time.UTC)
+	fireTime := now.Add(-10 * time.Minute)
+	for policy, expected := range map[string]int{
+		MisfireSkip:     2,
+		MisfireFireOnce: 3,
+		MisfireFireAll:  5,
+	} {
+		plan, err := newSchedulePlan(&JobConf{
+			Cron:           "0 * * * * *",
+			MisfirePolicy:  policy,
+			MisfireMaxRuns: 3,
+			MisfireGrace:   120,
+		}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		missed := plan.missedTimes(fireTime, now)
+		if len(missed) != 10 {
+			t.Fatalf("missed %d runs, expected 10", len(missed))
+		}
+		fires := plan.misfireTimes(missed, now)
+		if len(fires) != expected {
+			t.Fatalf("the policy: %s fire %d runs, expected %d", policy, len(fires), expected)
+		}
+		if !fires[len(fires)-1].Equal(missed[len(missed)-1]) {
+			t.Fatalf("the policy: %s must fire the runs within the grace window", policy)
+		}
+	}
+
+	plan, err := newSchedulePlan(&JobConf{Cron: "0 * * * * *", MisfirePolicy: MisfireFireOnce}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missed := plan.missedTimes(now.AddDate(0, 0, -7), now)
+	if len(missed) != misfireScanLimit || !missed[len(missed)-1].Equal(now.Truncate(time.Minute)) {
+		t.Fatalf("the latest missed times not kept: %d, %v", len(missed), missed[len(missed)-1])
+	}
+	if fires := plan.misfireTimes(missed, now); len(fires) != 1 || !fires[0].Equal(now.Truncate(time.Minute)) {
+		t.Fatalf("the fire-once policy fire the stale time: %v", fires)
+	}
+}
+
+func TestSchedulePlanFixedRateAndDelay(t *testing.T) {
+	now := time.Date(2026, 1, 1, 8, 0, 7, 0, time.UTC)
+	rate, err := newSchedulePlan(&JobConf{Id: "rate", ScheduleType: ScheduleFixedRate, Interval: 30}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2026, 1, 1, 8, 0, 30, 0, time.UTC); !rate.NextTime.Equal(expected) {
+		t.Fatalf("the fixed-rate next time: %v, expected %v", rate.NextTime, expected)
+	}
+
+	delay, err := newSchedulePlan(&JobConf{Id: "delay", ScheduleType: ScheduleFixedDelay, Interval: 30}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sch := newTestScheduler(0, now)
+	sch.putPlan(delay)
+	sch.schedule(delay.NextTime.Add(time.Second))
+	if !delay.Waiting || !delay.NextTime.IsZero() || sch.planQueue.Len() != 0 {
+		t.Fatalf("the fixed-delay plan should wait for the execution finished: %#v", delay)
+	}
+	sch.handleJobExecuteFinishedEvent(&JobChangeEvent{Type: JobExecuteFinishedChangeEvent, Conf: &JobConf{Id: "delay"}})
+	if delay.Waiting || delay.NextTime.IsZero() || sch.planQueue.Len() != 1 {
+		t.Fatalf("the fixed-delay plan should be scheduled after the execution finished: %#v", delay)
+	}
+}
+
+func TestCheckWaitingPlans(t *testing.T) {
+	now := time.Date(2026, 1, 1, 8, 0, 7, 0, time.UTC)
+	sch := newTestScheduler(0, now)
+	sch.node.state = NodeLeaderState
+	sch.node.limiter = &JobLimiter{running: make(map[string]*limitEntry), groups: make(map[string]int), jobs: make(map[string]int), lk: &sync.Mutex{}}
+	timeout, _ := newSchedulePlan(&JobConf{Id: "timeout", ScheduleType: ScheduleFixedDelay, Interval: 30, Timeout: 60}, now)
+	forever, _ := newSchedulePlan(&JobConf{Id: "forever", ScheduleType: ScheduleFixedDelay, Interval: 30}, now)
+	for _, plan := range []*SchedulePlan{timeout, forever} {
+		sch.putPlan(plan)
+		sch.waitPlan(plan, now)
+	}
+	sch.node.limiter.track("running", "g", "forever", "c")
+
+	sch.checkWaitingPlans(now.Add(60 * time.Second))
+	if !timeout.Waiting || !forever.Waiting {
+		t.Fatal("the waiting plans resumed before the deadline")
+	}
+	sch.checkWaitingPlans(now.Add(91 * time.Second))
+	if timeout.Waiting || timeout.NextTime.IsZero() {
+		t.Fatalf("the plan not resumed after the interval plus the timeout: %#v", timeout)
+	}
+	if !forever.Waiting {
+		t.Fatal("the plan without the timeout resumed while the execution is in-flight")
+	}
+	sch.node.limiter.done("running")
+	sch.checkWaitingPlans(forever.waitDeadline.Add(time.Second))
+	if forever.Waiting || sch.planQueue.Len() != 2 {
+		t.Fatalf("the plan not resumed after the execution lost: %#v", forever)
+	}
+}
+
+func TestSchedulePlanActiveWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	schedule, err := ParseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &SchedulePlan{
+		schedule: schedule,
+		startAt:  now.Add(24 * time.Hour),
+		endAt:    now.Add(26 * time.Hour),
+	}
+	if next := plan.next(now); !next.Equal(plan.startAt) {
+		t.Fatalf("the plan fired before the start: %v", next)
+	}
+	if next := plan.next(now.Add(25 * time.Hour)); !next.Equal(plan.endAt) {
+		t.Fatalf("the plan not fired at the end: %v", next)
+	}
+	if next := plan.next(plan.endAt); !next.IsZero() {
+		t.Fatalf("the plan fired after the end: %v", next)
+	}
+
+	plan = &SchedulePlan{schedule: cron.Every(time.Hour), MaxRuns: 2, Runs: 2}
+	if next := plan.next(now); !next.IsZero() || plan.finishReason() == "" {
+		t.Fatalf("the plan fired after the max runs: %v", next)
+	}
+}
+
+func TestSchedulePlanJitter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	schedule, err := ParseCron("* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sch := newTestScheduler(0, now)
+	plan := &SchedulePlan{Id: "job", schedule: schedule, Jitter: 90, JitterMode: JitterHash}
+	plan.NextTime = plan.next(now)
+	sch.putPlan(plan)
+	offset := plan.JitterOffset
+	if offset != jitterOffset("job", 90, JitterHash) || !plan.NextTime.Equal(now.Add(time.Minute+time.Duration(offset)*time.Second)) {
+		t.Fatalf("the next time: %v, the offset: %d", plan.NextTime, offset)
+	}
+	// the jitter longer than the interval never skip a run
+	if next := sch.nextTime(plan, plan.NextTime); !next.Equal(now.Add(2*time.Minute + time.Duration(offset)*time.Second)) {
+		t.Fatalf("the next time: %v, the offset: %d", next, offset)
+	}
+	for i := 0; i < 100; i++ {
+		if offset := jitterOffset("job", 10, JitterRandom); offset < 0 || offset > 10 {
+			t.Fatalf("the random offset: %d out of the jitter", offset)
+		}
+	}
+}
+
+func TestScheduleWaitRunsLoaded(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sch := newTestScheduler(0, now)
+	sch.runs = map[string]int{"job": 2}
+	plan := &SchedulePlan{Id: "job", schedule: cron.Every(time.Minute), MaxRuns: 3, NextTime: now.Add(-time.Second)}
+	sch.putPlan(plan)
+	if plan.Runs != 2 {
+		t.Fatalf("the run count of the plan: %d", plan.Runs)
+	}
+	sch.node.state = NodeLeaderState
+	duration, fires := sch.schedule(now)
+	if len(fires) > 0 || duration != time.Second || sch.planQueue.peek() != plan || !plan.NextTime.Equal(now.Add(-time.Second)) {
+		t.Fatalf("the new leader fired before the run counts loaded: %v, %v, %#v", duration, fires, plan)
+	}
+}
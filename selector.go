@@ -0,0 +1,131 @@
+package forest
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	SelectorRandom      = "random"
+	SelectorRoundRobin  = "round-robin"
+	SelectorWeighted    = "weighted"
+	SelectorLeastLoaded = "least-loaded"
+)
+
+// ClientSelector select a client for the snapshot from the clients of the group
+type ClientSelector interface {
+	Select(group *Group, clients []*Client, snapshot *JobSnapshot) (*Client, error)
+}
+
+var (
+	clientSelectors = map[string]func() ClientSelector{
+		SelectorRandom:      func() ClientSelector { return &RandomSelector{} },
+		SelectorRoundRobin:  func() ClientSelector { return &RoundRobinSelector{} },
+		SelectorWeighted:    func() ClientSelector { return &WeightedSelector{} },
+		SelectorLeastLoaded: func() ClientSelector { return &LeastLoadedSelector{} },
+	}
+	clientSelectorsLk = &sync.RWMutex{}
+)
+
+// RegisterClientSelector register a client selector, the group select it by the name in the group conf
+func RegisterClientSelector(name string, fn func() ClientSelector) {
+	clientSelectorsLk.Lock()
+	defer clientSelectorsLk.Unlock()
+	clientSelectors[name] = fn
+}
+
+// NewClientSelector new a client selector by the name, the empty name means the random selector
+func NewClientSelector(name string) (ClientSelector, error) {
+	if len(name) == 0 {
+		name = SelectorRandom
+	}
+	clientSelectorsLk.RLock()
+	defer clientSelectorsLk.RUnlock()
+	fn, ok := clientSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("the client selector: %s not found", name)
+	}
+	return fn(), nil
+}
+
+// RandomSelector select a client randomly
+type RandomSelector struct {
+}
+
+func (s *RandomSelector) Select(group *Group, clients []*Client, snapshot *JobSnapshot) (*Client, error) {
+	return clients[rand.Intn(len(clients))], nil
+}
+
+// RoundRobinSelector select the clients in turn
+type RoundRobinSelector struct {
+	next uint64
+}
+
+func (s *RoundRobinSelector) Select(group *Group, clients []*Client, snapshot *JobSnapshot) (*Client, error) {
+	n := atomic.AddUint64(&s.next, 1) - 1
+	return clients[n%uint64(len(clients))], nil
+}
+
+// WeightedSelector select a client randomly by the weight published by the client
+type WeightedSelector struct {
+}
+
+func (s *WeightedSelector) Select(group *Group, clients []*Client, snapshot *JobSnapshot) (*Client, error) {
+	total := 0
+	for _, c := range clients {
+		total += c.weight()
+	}
+	pos := rand.Intn(total)
+	for _, c := range clients {
+		if pos < c.weight() {
+			return c, nil
+		}
+		pos -= c.weight()
+	}
+	return clients[len(clients)-1], nil
+}
+
+// LeastLoadedSelector select the client which has the least snapshots in flight,
+// counted by the slots the group tracked without any etcd access
+type LeastLoadedSelector struct {
+}
+
+func (s *LeastLoadedSelector) Select(group *Group, clients []*Client, snapshot *JobSnapshot) (client *Client, err error) {
+	least := -1
+	for _, c := range clients {
+		if used := group.usedSlots(c); least == -1 || used < least {
+			least = used
+			client = c
+		}
+	}
+	return
+}
+
+// HashSelector select the client by the consistent hash of the job id,
+// the job keep landing on the same client unless it disappears
+type HashSelector struct {
+}
+
+func (s *HashSelector) Select(group *Group, clients []*Client, snapshot *JobSnapshot) (*Client, error) {
+	if snapshot == nil || group.ring == nil {
+		return clients[rand.Intn(len(clients))], nil
+	}
+	key := snapshot.JobId
+	if len(key) == 0 {
+		key = snapshot.Id
+	}
+	candidates := make(map[string]*Client, len(clients))
+	for _, c := range clients {
+		candidates[c.name] = c
+	}
+	name := group.ring.get(key, func(name string) bool {
+		_, ok := candidates[name]
+		return ok
+	})
+	if client, ok := candidates[name]; ok {
+		return client, nil
+	}
+	return nil, fmt.Errorf("the group: %s, has no client on the hash ring for: %s", group.name, key)
+}
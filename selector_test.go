@@ -0,0 +1,74 @@
+package forest
+
+import "testing"
+
+func TestClientSelectors(t *testing.T) {
+	group := &Group{name: "g", assigned: make(map[string]map[string]bool)}
+	a := &Client{name: "a", meta: &ClientMeta{Name: "a", Weight: 3}}
+	b := &Client{name: "b", meta: &ClientMeta{Name: "b", Running: 1}}
+	c := &Client{name: "c"}
+	clients := []*Client{a, b, c}
+
+	roundRobin, _ := NewClientSelector(SelectorRoundRobin)
+	var names string
+	for i := 0; i < 4; i++ {
+		client, _ := roundRobin.Select(group, clients, nil)
+		names += client.name
+	}
+	if names != "abca" {
+		t.Fatalf("the round-robin order: %s", names)
+	}
+
+	weighted, _ := NewClientSelector(SelectorWeighted)
+	counts := make(map[string]int)
+	for i := 0; i < 5000; i++ {
+		client, _ := weighted.Select(group, clients, nil)
+		counts[client.name]++
+	}
+	if counts["a"] < counts["b"]*2 || counts["a"] < counts["c"]*2 {
+		t.Fatalf("the weighted counts: %v", counts)
+	}
+
+	leastLoaded, _ := NewClientSelector(SelectorLeastLoaded)
+	group.assign("a", "s1")
+	group.assign("c", "s2")
+	group.assign("c", "s3")
+	if client, err := leastLoaded.Select(group, clients, nil); err != nil || client != a {
+		t.Fatalf("the least loaded client: %v, %v", client, err)
+	}
+	group.assign("a", "s4")
+	if client, _ := leastLoaded.Select(group, clients, nil); client != b {
+		t.Fatalf("the running count reported by the client not counted: %v", client)
+	}
+
+	if _, err := NewClientSelector("unknown"); err == nil {
+		t.Fatal("the unknown selector not rejected")
+	}
+	if selector, err := NewClientSelector(""); err != nil {
+		t.Fatalf("the default selector: %v, %v", selector, err)
+	}
+}
+
+func TestGroupUnpackClientMeta(t *testing.T) {
+	group := &Group{name: "g", watchPath: "/forest/client/g/clients/"}
+	cases := []struct {
+		value []byte
+		name  string
+	}{
+		{[]byte("192.168.1.2"), "192.168.1.2"},
+		{[]byte(`{"name":"c1","capacity":2}`), "c1"},
+		{[]byte(`{"capacity":2}`), "c2"},
+	}
+	for _, c := range cases {
+		meta, err := group.unpackClientMeta(group.watchPath+"c2", c.value)
+		if err != nil || meta.Name != c.name {
+			t.Fatalf("unpack the client: %s, %v, %v", c.value, meta, err)
+		}
+	}
+	if _, err := group.unpackClientMeta(group.watchPath, []byte(`{"capacity":2}`)); err == nil {
+		t.Fatal("the client without the name not rejected")
+	}
+	if _, err := group.unpackClientMeta(group.watchPath+"c2", []byte(`{"name":`)); err == nil {
+		t.Fatal("the invalid client value not rejected")
+	}
+}
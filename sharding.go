@@ -0,0 +1,184 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/webx-top/db"
+)
+
+// ShardTotalLimit the max shards of a sharding job
+var ShardTotalLimit = 1000
+
+// check the snapshot is a shard of the broadcast or sharding execution
+func (s *JobSnapshot) isShard() bool {
+	return s.ShardTotal > 0 && s.ShardIndex >= 0
+}
+
+// check the execute snapshot is a shard of the broadcast or sharding execution
+func (s *JobExecuteSnapshot) isShard() bool {
+	return s.ShardTotal > 0 && s.ShardIndex >= 0
+}
+
+// dispatch the shards of the broadcast or sharding job
+func (exec *JobExecutor) handleJobShards(snapshot *JobSnapshot, conf *JobConf) error {
+	var (
+		clients []*Client
+		err     error
+		total   int
+	)
+	switch conf.Mode {
+	case JobModeBroadcast:
+		if clients, err = exec.node.groupManager.selectClients(snapshot.Group, conf); err != nil {
+			if errors.Is(err, ErrNoClientMatched) {
+				exec.node.collection.recordJobSnapshot(snapshot, JobExecuteSnapshotErrorStatus, "没有匹配标签选择器的客户端: "+conf.LabelSelector)
+			}
+			if errors.Is(err, ErrNoClient) {
+				return exec.node.pending.put(snapshot)
+			}
+			return fmt.Errorf("the group: %s, select the clients error: %w", snapshot.Group, err)
+		}
+		total = len(clients)
+	case JobModeSharding:
+		total = conf.ShardTotal
+	}
+
+	// the parent snapshot
+	snapshot.ShardIndex = -1
+	snapshot.ShardTotal = total
+	exec.node.collection.recordShardParent(snapshot)
+
+	for index, shard := range snapshot.newShards(conf.ShardParams) {
+		var client *Client
+		if clients != nil {
+			client = clients[index]
+		} else if client, err = exec.node.groupManager.selectClient(shard.Group, shard, conf); err != nil {
+			if errors.Is(err, ErrNoFreeSlot) {
+				// dispatch the shard when a slot is free
+				if err = exec.node.limiter.wait(shard, err); errors.Is(err, errSnapshotWaiting) {
+					continue
+				}
+			} else if errors.Is(err, ErrNoClient) {
+				// dispatch the shard when a client registered
+				if err = exec.node.pending.put(shard); errors.Is(err, errSnapshotPending) {
+					continue
+				}
+			}
+			exec.node.collection.recordJobSnapshot(shard, JobExecuteSnapshotErrorStatus, err.Error())
+			log.Errorf("the group: %s, select a client for the shard: %s error: %v", shard.Group, shard.Id, err)
+			continue
+		}
+		if err = exec.dispatch(shard, client); err != nil {
+			exec.node.collection.recordJobSnapshot(shard, JobExecuteSnapshotErrorStatus, err.Error())
+			log.Error(err)
+		}
+	}
+	return nil
+}
+
+// new the shards of the parent snapshot, the shard use the params of the same index or the params of the parent
+func (s *JobSnapshot) newShards(params []string) []*JobSnapshot {
+	shards := make([]*JobSnapshot, s.ShardTotal)
+	for index := range shards {
+		shard := *s
+		shard.Id = s.Id + "-" + strconv.Itoa(index)
+		shard.ShardParentId = s.Id
+		shard.RetryOf = ""
+		shard.Attempt = 0
+		shard.ShardIndex = index
+		if index < len(params) {
+			shard.Params = params[index]
+		}
+		shards[index] = &shard
+	}
+	return shards
+}
+
+// record the parent of the shards
+func (c *JobCollection) recordShardParent(snapshot *JobSnapshot) {
+	executeSnapshot := newExecuteSnapshot(snapshot, JobExecuteSnapshotDoingStatus, "")
+	executeSnapshot.FinishTime = ""
+	executeSnapshot.ShardIndex = snapshot.ShardIndex
+	executeSnapshot.ShardTotal = snapshot.ShardTotal
+	if _, err := c.node.UseTable(TableJobExecuteSnapshot).Insert(executeSnapshot); err != nil {
+		log.Errorf("record the parent snapshot: %s error: %v", snapshot.Id, err)
+	}
+}
+
+// the doing shards of the parent, the shards are recorded when dispatched
+func (c *JobCollection) doingShards(parentId string) (shards []*JobExecuteSnapshot, err error) {
+	err = c.node.UseTable(TableJobExecuteSnapshot).
+		Find(db.Cond{`shard_parent_id`: parentId, `shard_index >=`: 0, `status`: JobExecuteSnapshotDoingStatus}).
+		All(&shards)
+	return
+}
+
+// check the shards of the parent finished
+func (c *JobCollection) checkShards(parentId string) {
+	c.lk.Lock()
+	parent := c.aggregateShards(parentId)
+	c.lk.Unlock()
+	if parent != nil {
+		c.handleJobExecuteSnapshotFinished(parent)
+	}
+}
+
+// aggregate the status of the parent when all the shards finished, return the parent just finished
+func (c *JobCollection) aggregateShards(parentId string) *JobExecuteSnapshot {
+	var (
+		parent *JobExecuteSnapshot
+		shards []*JobExecuteSnapshot
+		err    error
+	)
+	if parent, err = c.findExecuteSnapshot(parentId); err != nil || parent == nil {
+		log.Warnf("the parent snapshot: %s not found: %v", parentId, err)
+		return nil
+	}
+	if IsFinishedStatus(parent.Status) {
+		return nil
+	}
+	err = c.node.UseTable(TableJobExecuteSnapshot).
+		Find(db.Cond{`shard_parent_id`: parentId, `shard_index >=`: 0}).
+		All(&shards)
+	if err != nil {
+		log.Errorf("load the shards of the parent snapshot: %s error: %v", parentId, err)
+		return nil
+	}
+	finished, success, failure := shardResult(shards, parent.ShardTotal)
+	if !finished {
+		return nil
+	}
+	parent.Status = JobExecuteSnapshotSuccessStatus
+	if failure > 0 {
+		parent.Status = JobExecuteSnapshotErrorStatus
+	}
+	parent.FinishTime = ToDateString(time.Now())
+	parent.Result = fmt.Sprintf("分片执行完成: 成功 %d, 失败 %d", success, failure)
+	err = c.node.UseTable(TableJobExecuteSnapshot).Find(db.Cond{`id`: parentId}).Update(parent)
+	if err != nil {
+		log.Errorf("update the parent snapshot: %s error: %v", parentId, err)
+		return nil
+	}
+	log.Infof("the parent snapshot: %s finished, success: %d, failure: %d", parentId, success, failure)
+	return parent
+}
+
+// count the finished shards, all the shards finished when the count reach the total,
+// the shards never run such as the replaced ones count as the failures
+func shardResult(shards []*JobExecuteSnapshot, total int) (finished bool, success int, failure int) {
+	for _, shard := range shards {
+		if shard.Status == JobExecuteSnapshotDoingStatus {
+			continue
+		}
+		if shard.Status == JobExecuteSnapshotSuccessStatus {
+			success++
+		} else {
+			failure++
+		}
+	}
+	finished = success+failure >= total
+	return
+}
@@ -0,0 +1,80 @@
+package forest
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestJobSnapshotNewShards(t *testing.T) {
+	parent := &JobSnapshot{Id: "p", JobId: "a", Params: "job", RetryOf: "p0", Attempt: 1, ShardIndex: -1}
+
+	// broadcast: one shard per client, all the shards use the params of the job
+	parent.ShardTotal = 3
+	shards := parent.newShards(nil)
+	if len(shards) != 3 {
+		t.Fatalf("the broadcast shards: %d", len(shards))
+	}
+	for index, shard := range shards {
+		if shard.Id != "p-"+strconv.Itoa(index) || shard.ShardParentId != "p" || shard.ShardIndex != index || shard.ShardTotal != 3 {
+			t.Fatalf("the shard: %#v", shard)
+		}
+		if !shard.isShard() || shard.Params != "job" || len(shard.RetryOf) > 0 || shard.Attempt != 0 {
+			t.Fatalf("the shard not split from the parent: %#v", shard)
+		}
+	}
+	if parent.isShard() {
+		t.Fatal("the parent is not a shard")
+	}
+
+	// sharding: the shards use the params of the same index
+	parent.ShardTotal = 3
+	shards = parent.newShards([]string{"s0", "s1"})
+	if shards[0].Params != "s0" || shards[1].Params != "s1" || shards[2].Params != "job" {
+		t.Fatalf("the params of the shards: %s, %s, %s", shards[0].Params, shards[1].Params, shards[2].Params)
+	}
+}
+
+func TestShardResult(t *testing.T) {
+	shards := []*JobExecuteSnapshot{
+		{Id: "p-0", Status: JobExecuteSnapshotSuccessStatus},
+		{Id: "p-1", Status: JobExecuteSnapshotDoingStatus},
+		{Id: "p-2", Status: JobExecuteSnapshotNoClientStatus},
+	}
+	if finished, success, failure := shardResult(shards, 3); finished || success != 1 || failure != 1 {
+		t.Fatalf("the shards not finished: %v, %d, %d", finished, success, failure)
+	}
+	shards[1].Status = JobExecuteSnapshotTimeoutStatus
+	if finished, success, failure := shardResult(shards, 3); !finished || success != 1 || failure != 2 {
+		t.Fatalf("the shards finished: %v, %d, %d", finished, success, failure)
+	}
+	if finished, _, _ := shardResult(shards[:1], 1); !finished {
+		t.Fatal("the single shard finished")
+	}
+	if finished, _, _ := shardResult(nil, 2); finished {
+		t.Fatal("the shards not recorded yet")
+	}
+}
+
+func TestShardMergeDispatched(t *testing.T) {
+	parent := &JobSnapshot{Id: "p", JobId: "a", ShardIndex: -1, ShardTotal: 2}
+	dispatched := newExecuteSnapshot(parent.newShards(nil)[1], JobExecuteSnapshotDoingStatus, "")
+	// the client not echo the shard fields
+	reported := &JobExecuteSnapshot{Id: "p-1", JobId: "a", Status: JobExecuteSnapshotSuccessStatus}
+	if reported.isShard() {
+		t.Fatal("the report without the shard fields is a shard")
+	}
+	reported.mergeDispatched(dispatched)
+	if !reported.isShard() || reported.ShardParentId != "p" || reported.ShardIndex != 1 || reported.ShardTotal != 2 {
+		t.Fatalf("the shard fields of the reported execution: %s, %d, %d", reported.ShardParentId, reported.ShardIndex, reported.ShardTotal)
+	}
+}
+
+func TestShardResultReplaced(t *testing.T) {
+	shards := []*JobExecuteSnapshot{
+		{Id: "p-0", Status: JobExecuteSnapshotSuccessStatus},
+		{Id: "p-1", Status: JobExecuteSnapshotSkippedStatus},
+	}
+	if finished, success, failure := shardResult(shards, 2); !finished || success != 1 || failure != 1 {
+		t.Fatalf("the replaced shard not finished: %v, %d, %d", finished, success, failure)
+	}
+}
@@ -1,7 +1,9 @@
 package forest
 
 const (
-	TableJobExecuteSnapshot = `job_execute_snapshot`
+	TableJobExecuteSnapshot   = `job_execute_snapshot`
+	TableWorkflowInstance     = `workflow_instance`
+	TableWorkflowInstanceNode = `workflow_instance_node`
 )
 
 var (
@@ -15,19 +17,48 @@ var (
 			"`target` varchar(255) NOT NULL COMMENT '目标任务',\n" +
 			"`params` varchar(2000) NOT NULL DEFAULT '' COMMENT '参数',\n" +
 			"`ip` varchar(32) NOT NULL DEFAULT '' COMMENT 'ip',\n" +
-			"`status` tinyint(4) NOT NULL DEFAULT '3' COMMENT '状态(1-执行中;2-完成;3-未知;4-错误)',\n" +
+			"`status` tinyint(4) NOT NULL DEFAULT '3' COMMENT '状态(1-执行中;2-完成;3-未知;5-跳过;6-超时;7-丢弃;8-无客户端;-1-错误)',\n" +
 			"`remark` varchar(255) NOT NULL DEFAULT '' COMMENT '备注',\n" +
 			"`create_time` varchar(32) NOT NULL DEFAULT '' COMMENT '创建时间',\n" +
 			"`start_time` varchar(32) NOT NULL DEFAULT '' COMMENT '开始时间',\n" +
 			"`finish_time` varchar(32) NOT NULL DEFAULT '' COMMENT '结束时间',\n" +
 			"`times` bigint(20) NOT NULL DEFAULT '0' COMMENT '耗时',\n" +
 			"`result` varchar(2000) NOT NULL DEFAULT '' COMMENT '返回结果',\n" +
+			"`attempt` int(11) NOT NULL DEFAULT '0' COMMENT '重试次数',\n" +
+			"`retry_of` varchar(64) NOT NULL DEFAULT '' COMMENT '上次尝试的执行快照id',\n" +
+			"`shard_parent_id` varchar(64) NOT NULL DEFAULT '' COMMENT '分片所属的执行快照id',\n" +
+			"`shard_index` int(11) NOT NULL DEFAULT '0' COMMENT '分片序号(-1-分片的上级)',\n" +
+			"`shard_total` int(11) NOT NULL DEFAULT '0' COMMENT '分片总数',\n" +
+			"`logical_time` varchar(32) NOT NULL DEFAULT '' COMMENT '补跑的逻辑执行时间',\n" +
 			"PRIMARY KEY (`id`),\n" +
 			"KEY `ip` (`ip`),\n" +
 			"KEY `job_id` (`job_id`),\n" +
 			"KEY `status` (`status`),\n" +
 			"KEY `target` (`target`),\n" +
-			"KEY `group` (`group`)\n" +
+			"KEY `group` (`group`),\n" +
+			"KEY `retry_of` (`retry_of`),\n" +
+			"KEY `shard_parent_id` (`shard_parent_id`)\n" +
 			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务作业执行快照';\n",
+		"CREATE TABLE `workflow_instance` (\n" +
+			"`id` varchar(64) NOT NULL COMMENT '主键',\n" +
+			"`workflow_id` varchar(32) NOT NULL DEFAULT '' COMMENT '工作流id',\n" +
+			"`name` varchar(120) NOT NULL DEFAULT '' COMMENT '工作流名称',\n" +
+			"`conf` text NOT NULL COMMENT '运行时的工作流配置',\n" +
+			"`status` tinyint(4) NOT NULL DEFAULT '1' COMMENT '状态(1-执行中;2-成功;4-取消;-1-失败)',\n" +
+			"`create_time` varchar(32) NOT NULL DEFAULT '' COMMENT '创建时间',\n" +
+			"`finish_time` varchar(32) NOT NULL DEFAULT '' COMMENT '结束时间',\n" +
+			"PRIMARY KEY (`id`),\n" +
+			"KEY `workflow_id` (`workflow_id`),\n" +
+			"KEY `status` (`status`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='工作流实例';\n",
+		"CREATE TABLE `workflow_instance_node` (\n" +
+			"`instance_id` varchar(64) NOT NULL COMMENT '工作流实例id',\n" +
+			"`job_id` varchar(32) NOT NULL COMMENT '任务定义id',\n" +
+			"`snapshot_id` varchar(64) NOT NULL DEFAULT '' COMMENT '执行快照id',\n" +
+			"`status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '状态(0-等待;1-执行中;2-成功;4-取消;5-跳过;-1-失败)',\n" +
+			"`update_time` varchar(32) NOT NULL DEFAULT '' COMMENT '更新时间',\n" +
+			"PRIMARY KEY (`instance_id`,`job_id`),\n" +
+			"KEY `snapshot_id` (`snapshot_id`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='工作流实例节点';\n",
 	}
 )
@@ -0,0 +1,151 @@
+package forest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// JobTimeoutChecker track the dispatched snapshots on the leader and kill the executions which exceed the timeout
+type JobTimeoutChecker struct {
+	node      *JobNode
+	deadlines map[string]*timeoutEntry
+	lk        *sync.Mutex
+}
+
+type timeoutEntry struct {
+	snapshot *JobSnapshot
+	deadline time.Time
+}
+
+func NewJobTimeoutChecker(node *JobNode) (checker *JobTimeoutChecker) {
+	checker = &JobTimeoutChecker{
+		node:      node,
+		deadlines: make(map[string]*timeoutEntry),
+		lk:        &sync.Mutex{},
+	}
+	go checker.loop()
+	return
+}
+
+// track the dispatched snapshot
+func (checker *JobTimeoutChecker) track(snapshot *JobSnapshot, start time.Time) {
+	if snapshot.Timeout <= 0 {
+		return
+	}
+	checker.lk.Lock()
+	defer checker.lk.Unlock()
+	checker.deadlines[snapshot.Id] = &timeoutEntry{
+		snapshot: snapshot,
+		deadline: start.Add(time.Duration(snapshot.Timeout) * time.Second),
+	}
+}
+
+// untrack the finished snapshot
+func (checker *JobTimeoutChecker) untrack(id string) {
+	checker.lk.Lock()
+	defer checker.lk.Unlock()
+	delete(checker.deadlines, id)
+}
+
+func (checker *JobTimeoutChecker) loop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if checker.node.state != NodeLeaderState {
+			continue
+		}
+		for _, entry := range checker.expired(now) {
+			checker.expire(entry)
+		}
+	}
+}
+
+// remove and return the expired entries
+func (checker *JobTimeoutChecker) expired(now time.Time) (entries []*timeoutEntry) {
+	checker.lk.Lock()
+	defer checker.lk.Unlock()
+	for id, entry := range checker.deadlines {
+		if entry.deadline.Before(now) {
+			entries = append(entries, entry)
+			delete(checker.deadlines, id)
+		}
+	}
+	return
+}
+
+// kill the expired execution and mark it timeout
+func (checker *JobTimeoutChecker) expire(entry *timeoutEntry) {
+	snapshot := entry.snapshot
+	log.Warnf("the snapshot: %s of the job: %s exceed the timeout: %ds, kill it", snapshot.Id, snapshot.JobId, snapshot.Timeout)
+	if err := checker.node.manager.Kill(snapshot); err != nil {
+		log.Warnf("kill the timeout snapshot: %s error: %v", snapshot.Id, err)
+	}
+	checker.node.collection.markTimeout(snapshot, fmt.Sprintf("执行超时(%d秒)", snapshot.Timeout))
+}
+
+// notify the node state change event, the new leader rebuild the deadlines from the snapshots
+// not started by the clients yet and the doing execute snapshots
+func (checker *JobTimeoutChecker) notify(state int) {
+	if state != NodeLeaderState {
+		return
+	}
+	confs := make(map[string]*JobConf)
+	if _, values, err := checker.node.etcd.GetWithPrefixKey(JobSnapshotPath); err != nil {
+		log.Errorf("load the dispatched snapshots error: %v", err)
+	} else {
+		now := time.Now()
+		for _, value := range values {
+			snapshot, err := UnpackJobSnapshot(value)
+			if err != nil {
+				continue
+			}
+			if snapshot.Timeout <= 0 && len(snapshot.JobId) > 0 {
+				snapshot.Timeout = checker.jobTimeout(confs, snapshot.JobId)
+			}
+			// the dispatch time is lost, the deadline start from now so the snapshot is never killed early
+			checker.track(snapshot, now)
+		}
+	}
+	_, values, err := checker.node.etcd.GetWithPrefixKey(JobExecuteStatusCollectionPath)
+	if err != nil {
+		log.Errorf("load the execute snapshots error: %v", err)
+		return
+	}
+	for _, value := range values {
+		executeSnapshot, err := UnpackJobExecuteSnapshot(value)
+		if err != nil || executeSnapshot.Status != JobExecuteSnapshotDoingStatus || len(executeSnapshot.JobId) == 0 {
+			continue
+		}
+		seconds := checker.jobTimeout(confs, executeSnapshot.JobId)
+		if seconds <= 0 {
+			continue
+		}
+		start, err := time.ParseInLocation("2006-01-02 15:04:05", executeSnapshot.StartTime, time.Local)
+		if err != nil {
+			start = time.Now()
+		}
+		snapshot := executeSnapshot.NewSnapshot()
+		snapshot.Ip = executeSnapshot.Ip
+		snapshot.Timeout = seconds
+		checker.track(snapshot, start)
+	}
+}
+
+// the timeout of the job, the confs loaded are cached during the rebuild
+func (checker *JobTimeoutChecker) jobTimeout(confs map[string]*JobConf, jobId string) int {
+	conf, ok := confs[jobId]
+	if !ok {
+		var err error
+		if conf, err = checker.node.manager.GetJob(jobId); err != nil {
+			conf = nil
+		}
+		confs[jobId] = conf
+	}
+	if conf == nil {
+		return 0
+	}
+	return conf.Timeout
+}
@@ -0,0 +1,58 @@
+package forest
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJobTimeoutChecker(t *testing.T) {
+	checker := &JobTimeoutChecker{deadlines: make(map[string]*timeoutEntry), lk: &sync.Mutex{}}
+	start := time.Date(2026, 10, 1, 10, 0, 0, 0, time.Local)
+	checker.track(&JobSnapshot{Id: "1", Timeout: 10}, start)
+	checker.track(&JobSnapshot{Id: "2", Timeout: 60}, start)
+	checker.track(&JobSnapshot{Id: "3", Timeout: 10}, start)
+	checker.track(&JobSnapshot{Id: "4"}, start)
+	if _, ok := checker.deadlines["4"]; ok {
+		t.Fatal("the snapshot without the timeout is tracked")
+	}
+	if deadline := checker.deadlines["1"].deadline; !deadline.Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("the deadline: %v", deadline)
+	}
+
+	checker.untrack("3")
+	if entries := checker.expired(start.Add(10 * time.Second)); len(entries) != 0 {
+		t.Fatalf("the snapshot expired at the deadline: %v", entries)
+	}
+	entries := checker.expired(start.Add(11 * time.Second))
+	if len(entries) != 1 || entries[0].snapshot.Id != "1" {
+		t.Fatalf("the expired snapshots: %v", entries)
+	}
+	if entries = checker.expired(start.Add(11 * time.Second)); len(entries) != 0 {
+		t.Fatalf("the expired snapshot returned twice: %v", entries)
+	}
+	if _, ok := checker.deadlines["2"]; !ok || len(checker.deadlines) != 1 {
+		t.Fatalf("the tracked deadlines: %v", checker.deadlines)
+	}
+}
+
+func TestTimeoutExecuteSnapshot(t *testing.T) {
+	snapshot := &JobSnapshot{Id: "1", JobId: "a", Timeout: 10}
+	executeSnapshot := finishExecuteSnapshot(nil, snapshot, JobExecuteSnapshotTimeoutStatus, "执行超时(10秒)")
+	if executeSnapshot == nil || executeSnapshot.Status != JobExecuteSnapshotTimeoutStatus || executeSnapshot.JobId != "a" {
+		t.Fatalf("the never recorded execution: %v", executeSnapshot)
+	}
+
+	old := &JobExecuteSnapshot{Id: "1", Status: JobExecuteSnapshotDoingStatus, StartTime: "2026-10-01 10:00:00"}
+	if executeSnapshot = finishExecuteSnapshot(old, snapshot, JobExecuteSnapshotTimeoutStatus, "执行超时(10秒)"); executeSnapshot != old {
+		t.Fatalf("the running execution not updated: %v", executeSnapshot)
+	}
+	if old.Status != JobExecuteSnapshotTimeoutStatus || old.StartTime != "2026-10-01 10:00:00" || len(old.FinishTime) == 0 || old.Result != "执行超时(10秒)" {
+		t.Fatalf("the updated execution: %v", old)
+	}
+
+	finished := &JobExecuteSnapshot{Id: "1", Status: JobExecuteSnapshotSuccessStatus}
+	if executeSnapshot = finishExecuteSnapshot(finished, snapshot, JobExecuteSnapshotTimeoutStatus, "执行超时(10秒)"); executeSnapshot != nil || finished.Status != JobExecuteSnapshotSuccessStatus {
+		t.Fatalf("the finished execution marked timeout: %v", executeSnapshot)
+	}
+}
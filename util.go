@@ -43,6 +43,17 @@ func UnpackGroupConf(value []byte) (groupConf *GroupConf, err error) {
 	return
 }
 
+// UnpackClientMeta unpack the client registration value, the plain value is the client name
+func UnpackClientMeta(value []byte) (meta *ClientMeta, err error) {
+	meta = new(ClientMeta)
+	if len(value) == 0 || value[0] != '{' {
+		meta.Name = string(value)
+		return
+	}
+	err = json.Unmarshal(value, meta)
+	return
+}
+
 func PackJobSnapshot(snapshot *JobSnapshot) (value []byte, err error) {
 	value, err = json.Marshal(snapshot)
 	return
@@ -65,6 +76,72 @@ func UnpackJobExecuteSnapshot(value []byte) (snapshot *JobExecuteSnapshot, err e
 	return
 }
 
+func PackJobFireState(state *JobFireState) (value []byte, err error) {
+	value, err = json.Marshal(state)
+	return
+}
+
+func UnpackJobFireState(value []byte) (state *JobFireState, err error) {
+	state = new(JobFireState)
+	err = json.Unmarshal(value, state)
+	return
+}
+
+func PackWorkflowConf(conf *WorkflowConf) (value []byte, err error) {
+	value, err = json.Marshal(conf)
+	return
+}
+
+func UnpackWorkflowConf(value []byte) (conf *WorkflowConf, err error) {
+	conf = new(WorkflowConf)
+	err = json.Unmarshal(value, conf)
+	return
+}
+
+func PackBackfill(backfill *Backfill) (value []byte, err error) {
+	value, err = json.Marshal(backfill)
+	return
+}
+
+func UnpackBackfill(value []byte) (backfill *Backfill, err error) {
+	backfill = new(Backfill)
+	err = json.Unmarshal(value, backfill)
+	return
+}
+
+func PackCordon(cordon *Cordon) (value []byte, err error) {
+	value, err = json.Marshal(cordon)
+	return
+}
+
+func UnpackCordon(value []byte) (cordon *Cordon, err error) {
+	cordon = new(Cordon)
+	err = json.Unmarshal(value, cordon)
+	return
+}
+
+func PackDelayedTask(task *DelayedTask) (value []byte, err error) {
+	value, err = json.Marshal(task)
+	return
+}
+
+func UnpackDelayedTask(value []byte) (task *DelayedTask, err error) {
+	task = new(DelayedTask)
+	err = json.Unmarshal(value, task)
+	return
+}
+
+func PackCalendar(calendar *Calendar) (value []byte, err error) {
+	value, err = json.Marshal(calendar)
+	return
+}
+
+func UnpackCalendar(value []byte) (calendar *Calendar, err error) {
+	calendar = new(Calendar)
+	err = json.Unmarshal(value, calendar)
+	return
+}
+
 func GetLocalIpAddress() (ip string) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -113,6 +190,14 @@ func TimeSubDays(t1, t2 time.Time) int {
 	return int(hours/24) + 1
 }
 
+// LoadLocation load the time zone by the IANA name, the empty name means the local time zone
+func LoadLocation(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
 func ParseInLocation(value string) (dateTime time.Time, err error) {
 	dateTime, err = time.Parse("2006-01-02 15:04:05", value)
 	return
@@ -0,0 +1,78 @@
+package forest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/admpub/log"
+)
+
+// the active window and the max runs of the job, the leader stop the job automatically
+// once the end reached or the runs exhausted
+
+// parse the time of the active window in the time zone of the job, the empty value means unlimited
+func parseJobTime(value string, location *time.Location) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", value, location)
+}
+
+// check the plan has exhausted the max runs
+func (plan *SchedulePlan) exhausted() bool {
+	return plan.MaxRuns > 0 && plan.Runs >= plan.MaxRuns
+}
+
+// the reason the plan will never fire again by the active window
+func (plan *SchedulePlan) finishReason() string {
+	if plan.exhausted() {
+		return fmt.Sprintf("已达到最大执行次数: %d", plan.MaxRuns)
+	}
+	if !plan.endAt.IsZero() {
+		return "已到达结束时间: " + plan.EndAt
+	}
+	return ""
+}
+
+// stop the job of the plan which will never fire again by the active window
+func (sch *JobScheduler) finishPlan(plan *SchedulePlan) {
+	if sch.node.state != NodeLeaderState || plan.delayed != nil {
+		return
+	}
+	reason := plan.finishReason()
+	if len(reason) == 0 {
+		return
+	}
+	go sch.node.manager.stopJob(plan.Id, reason)
+}
+
+// stop the job automatically with the reason
+func (manager *JobManager) stopJob(jobId string, reason string) {
+	value, err := manager.node.etcd.Get(JobConfPath + jobId)
+	if err != nil || len(value) == 0 {
+		log.Warnf("load the job conf: %s to stop error: %v", jobId, err)
+		return
+	}
+	conf, err := UnpackJobConf(value)
+	if err != nil {
+		log.Warnf("unpack the job conf: %s to stop error: %v", jobId, err)
+		return
+	}
+	if conf.Status == JobStopStatus {
+		return
+	}
+	conf.Status = JobStopStatus
+	conf.StopReason = reason
+	conf.Version++
+	v, err := PackJobConf(conf)
+	if err != nil {
+		log.Errorf("pack the job conf: %s to stop error: %v", jobId, err)
+		return
+	}
+	success, err := manager.node.etcd.Update(JobConfPath+jobId, string(v), string(value))
+	if err != nil || !success {
+		log.Errorf("stop the job: %s error: %v", jobId, err)
+		return
+	}
+	log.Warnf("the job: %s stopped automatically: %s", jobId, reason)
+}
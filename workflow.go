@@ -0,0 +1,531 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/log"
+	"github.com/webx-top/db"
+)
+
+const (
+	WorkflowConfPath = "/forest/server/workflow/"
+)
+
+// JobWorkflow advance the workflow instances as the jobs of them finished,
+// the state of the instances persisted in the database so the new leader continue them
+type JobWorkflow struct {
+	node *JobNode
+	lk   *sync.Mutex
+}
+
+func NewJobWorkflow(node *JobNode) (w *JobWorkflow) {
+	w = &JobWorkflow{
+		node: node,
+		lk:   &sync.Mutex{},
+	}
+	return
+}
+
+// AddWorkflow add workflow conf
+func (manager *JobManager) AddWorkflow(conf *WorkflowConf) (err error) {
+	var (
+		v       []byte
+		success bool
+	)
+	if err = manager.checkWorkflowConf(conf); err != nil {
+		return
+	}
+	conf.Id = GenerateSerialNo()
+	conf.Version = 1
+	if v, err = PackWorkflowConf(conf); err != nil {
+		return
+	}
+	if success, _, err = manager.node.etcd.PutNotExist(WorkflowConfPath+conf.Id, string(v)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("创建失败,请重试！")
+	}
+	return
+}
+
+// EditWorkflow edit workflow conf, the running instances keep the conf when they created
+func (manager *JobManager) EditWorkflow(conf *WorkflowConf) (err error) {
+	var (
+		value   []byte
+		v       []byte
+		success bool
+		oldConf *WorkflowConf
+	)
+	if len(conf.Id) == 0 {
+		err = errors.New("此工作流不存在")
+		return
+	}
+	if err = manager.checkWorkflowConf(conf); err != nil {
+		return
+	}
+	if value, err = manager.node.etcd.Get(WorkflowConfPath + conf.Id); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此工作流不存在")
+		return
+	}
+	if oldConf, err = UnpackWorkflowConf(value); err != nil {
+		return
+	}
+	conf.Version = oldConf.Version + 1
+	if v, err = PackWorkflowConf(conf); err != nil {
+		return
+	}
+	if success, err = manager.node.etcd.Update(WorkflowConfPath+conf.Id, string(v), string(value)); err != nil {
+		return
+	}
+	if !success {
+		err = errors.New("修改失败,请重试！")
+	}
+	return
+}
+
+// DeleteWorkflow delete workflow conf
+func (manager *JobManager) DeleteWorkflow(conf *WorkflowConf) (err error) {
+	var value []byte
+	if len(conf.Id) == 0 {
+		err = errors.New("此工作流不存在")
+		return
+	}
+	if value, err = manager.node.etcd.Get(WorkflowConfPath + conf.Id); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		err = errors.New("此工作流不存在")
+		return
+	}
+	err = manager.node.etcd.Delete(WorkflowConfPath + conf.Id)
+	return
+}
+
+// GetWorkflow get the workflow conf
+func (manager *JobManager) GetWorkflow(id string) (conf *WorkflowConf, err error) {
+	value, err := manager.node.etcd.Get(WorkflowConfPath + id)
+	if err != nil {
+		return nil, fmt.Errorf("查询工作流配置出现异常: %w", err)
+	}
+	if len(value) == 0 {
+		return nil, errors.New("此工作流不存在")
+	}
+	conf, err = UnpackWorkflowConf(value)
+	if err != nil {
+		return nil, fmt.Errorf("非法的工作流配置内容: %w", err)
+	}
+	return
+}
+
+// WorkflowList workflow list
+func (manager *JobManager) WorkflowList() (confs []*WorkflowConf, err error) {
+	var values [][]byte
+	if _, values, err = manager.node.etcd.GetWithPrefixKey(WorkflowConfPath); err != nil {
+		return
+	}
+	confs = make([]*WorkflowConf, 0, len(values))
+	for _, value := range values {
+		conf, err := UnpackWorkflowConf(value)
+		if err != nil {
+			log.Errorf("unpack the workflow conf error: %#v", err)
+			continue
+		}
+		confs = append(confs, conf)
+	}
+	return
+}
+
+// check the workflow conf before save
+func (manager *JobManager) checkWorkflowConf(conf *WorkflowConf) (err error) {
+	if len(conf.Name) == 0 {
+		err = errors.New("工作流名称不能为空")
+		return
+	}
+	if err = checkWorkflowDAG(conf); err != nil {
+		return
+	}
+	for _, jobId := range conf.Nodes {
+		if _, err = manager.GetJob(jobId); err != nil {
+			err = fmt.Errorf("工作流节点: %s, %w", jobId, err)
+			return
+		}
+	}
+	return
+}
+
+// check the nodes and edges of the workflow make up a directed acyclic graph
+func checkWorkflowDAG(conf *WorkflowConf) error {
+	if len(conf.Nodes) == 0 {
+		return errors.New("工作流节点不能为空")
+	}
+	indegrees := make(map[string]int, len(conf.Nodes))
+	for _, jobId := range conf.Nodes {
+		if _, ok := indegrees[jobId]; ok {
+			return fmt.Errorf("工作流节点重复: %s", jobId)
+		}
+		indegrees[jobId] = 0
+	}
+	downstreams := make(map[string][]string)
+	for _, edge := range conf.Edges {
+		if _, ok := indegrees[edge.From]; !ok {
+			return fmt.Errorf("工作流连线的上游节点不存在: %s", edge.From)
+		}
+		if _, ok := indegrees[edge.To]; !ok {
+			return fmt.Errorf("工作流连线的下游节点不存在: %s", edge.To)
+		}
+		switch edge.On {
+		case "", WorkflowEdgeSuccess, WorkflowEdgeFailure, WorkflowEdgeAlways:
+		default:
+			return fmt.Errorf("非法的工作流连线条件: %s", edge.On)
+		}
+		downstreams[edge.From] = append(downstreams[edge.From], edge.To)
+		indegrees[edge.To]++
+	}
+	var queue []string
+	for _, jobId := range conf.Nodes {
+		if indegrees[jobId] == 0 {
+			queue = append(queue, jobId)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		jobId := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, to := range downstreams[jobId] {
+			indegrees[to]--
+			if indegrees[to] == 0 {
+				queue = append(queue, to)
+			}
+		}
+	}
+	if visited < len(conf.Nodes) {
+		var cycle []string
+		for _, jobId := range conf.Nodes {
+			if indegrees[jobId] > 0 {
+				cycle = append(cycle, jobId)
+			}
+		}
+		return fmt.Errorf("工作流存在循环依赖: %s", strings.Join(cycle, ","))
+	}
+	return nil
+}
+
+// check the finished node match the condition of the edge
+func workflowEdgeSatisfied(on string, status int) bool {
+	switch on {
+	case WorkflowEdgeFailure:
+		return status == WorkflowNodeFailureStatus
+	case WorkflowEdgeAlways:
+		return status == WorkflowNodeSuccessStatus || status == WorkflowNodeFailureStatus
+	default:
+		return status == WorkflowNodeSuccessStatus
+	}
+}
+
+// check the node status is finished
+func isWorkflowNodeFinished(status int) bool {
+	return status != WorkflowNodeWaitingStatus && status != WorkflowNodeDoingStatus
+}
+
+// the waiting nodes which all the upstreams finished, the node is ready if all the edges
+// to it are satisfied, otherwise it is skipped
+func workflowNextNodes(conf *WorkflowConf, states map[string]int) (ready []string, skipped []string) {
+	upstreams := make(map[string][]*WorkflowEdge)
+	for _, edge := range conf.Edges {
+		upstreams[edge.To] = append(upstreams[edge.To], edge)
+	}
+NEXT:
+	for _, jobId := range conf.Nodes {
+		if states[jobId] != WorkflowNodeWaitingStatus {
+			continue
+		}
+		satisfied := true
+		for _, edge := range upstreams[jobId] {
+			status := states[edge.From]
+			if !isWorkflowNodeFinished(status) {
+				continue NEXT
+			}
+			if !workflowEdgeSatisfied(edge.On, status) {
+				satisfied = false
+			}
+		}
+		if satisfied {
+			ready = append(ready, jobId)
+		} else {
+			skipped = append(skipped, jobId)
+		}
+	}
+	return
+}
+
+// the status of the instance when all the nodes finished
+func workflowInstanceStatus(states map[string]int) (status int, finished bool) {
+	status = WorkflowInstanceSuccessStatus
+	for _, state := range states {
+		if !isWorkflowNodeFinished(state) {
+			return WorkflowInstanceDoingStatus, false
+		}
+		if state == WorkflowNodeFailureStatus {
+			status = WorkflowInstanceFailureStatus
+		}
+	}
+	return status, true
+}
+
+// Run create an instance of the workflow and dispatch the jobs without upstreams
+func (w *JobWorkflow) Run(workflowId string) (instance *WorkflowInstance, err error) {
+	var (
+		conf  *WorkflowConf
+		value []byte
+	)
+	if conf, err = w.node.manager.GetWorkflow(workflowId); err != nil {
+		return
+	}
+	if value, err = PackWorkflowConf(conf); err != nil {
+		return
+	}
+	now := ToDateString(time.Now())
+	instance = &WorkflowInstance{
+		Id:         GenerateSerialNo() + conf.Id,
+		WorkflowId: conf.Id,
+		Name:       conf.Name,
+		Conf:       string(value),
+		Status:     WorkflowInstanceDoingStatus,
+		CreateTime: now,
+	}
+	if _, err = w.node.UseTable(TableWorkflowInstance).Insert(instance); err != nil {
+		return
+	}
+	for _, jobId := range conf.Nodes {
+		node := &WorkflowInstanceNode{
+			InstanceId: instance.Id,
+			JobId:      jobId,
+			Status:     WorkflowNodeWaitingStatus,
+			UpdateTime: now,
+		}
+		if _, err = w.node.UseTable(TableWorkflowInstanceNode).Insert(node); err != nil {
+			return
+		}
+	}
+	log.Infof("the workflow: %s run as the instance: %s", conf.Id, instance.Id)
+
+	w.lk.Lock()
+	snapshots := w.advance(instance)
+	w.lk.Unlock()
+	w.dispatch(snapshots)
+	return
+}
+
+// Cancel cancel the instance, kill the doing jobs and never dispatch the waiting jobs
+func (w *JobWorkflow) Cancel(instanceId string) (err error) {
+	var (
+		instance *WorkflowInstance
+		nodes    []*WorkflowInstanceNode
+	)
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	if instance, err = w.findInstance(instanceId); err != nil {
+		return
+	}
+	if instance == nil {
+		return errors.New("此工作流实例不存在")
+	}
+	if instance.Status != WorkflowInstanceDoingStatus {
+		return errors.New("此工作流实例已经结束")
+	}
+	if nodes, err = w.loadNodes(instanceId); err != nil {
+		return
+	}
+	for _, node := range nodes {
+		if isWorkflowNodeFinished(node.Status) {
+			continue
+		}
+		if node.Status == WorkflowNodeDoingStatus {
+			w.kill(node.SnapshotId)
+		}
+		node.Status = WorkflowNodeCanceledStatus
+		w.updateNode(node)
+	}
+	instance.Status = WorkflowInstanceCanceledStatus
+	instance.FinishTime = ToDateString(time.Now())
+	return w.node.UseTable(TableWorkflowInstance).Find(db.Cond{`id`: instance.Id}).Update(instance)
+}
+
+// kill the doing snapshot of the canceled node
+func (w *JobWorkflow) kill(snapshotId string) {
+	executeSnapshot, err := w.node.collection.findExecuteSnapshot(snapshotId)
+	if err != nil || executeSnapshot == nil || IsFinishedStatus(executeSnapshot.Status) {
+		return
+	}
+	if err = w.node.manager.Kill(&JobSnapshot{Id: executeSnapshot.Id, Group: executeSnapshot.Group, Ip: executeSnapshot.Ip}); err != nil {
+		log.Warnf("kill the snapshot: %s of the canceled workflow error: %v", snapshotId, err)
+	}
+}
+
+// handle the finished execute snapshot of the job, advance the instance if it is a node of the workflow
+func (w *JobWorkflow) handleSnapshotFinished(snapshot *JobExecuteSnapshot) {
+	w.finishNode(snapshot.Id, workflowNodeStatus(snapshot.Status))
+}
+
+// the status of the node by the status of the execution, the skipped and the dropped
+// executions never run so the node is skipped and no edge from it is satisfied
+func workflowNodeStatus(status int) int {
+	switch status {
+	case JobExecuteSnapshotSuccessStatus:
+		return WorkflowNodeSuccessStatus
+	case JobExecuteSnapshotSkippedStatus, JobExecuteSnapshotDroppedStatus:
+		return WorkflowNodeSkippedStatus
+	default:
+		return WorkflowNodeFailureStatus
+	}
+}
+
+// finish the node of the snapshot and dispatch the downstream jobs
+func (w *JobWorkflow) finishNode(snapshotId string, status int) {
+	w.lk.Lock()
+	node, err := w.findNode(snapshotId)
+	if err != nil || node == nil || node.Status != WorkflowNodeDoingStatus {
+		w.lk.Unlock()
+		return
+	}
+	node.Status = status
+	w.updateNode(node)
+	var snapshots []*JobSnapshot
+	instance, err := w.findInstance(node.InstanceId)
+	if err != nil || instance == nil {
+		log.Warnf("the workflow instance: %s not found: %v", node.InstanceId, err)
+	} else {
+		snapshots = w.advance(instance)
+	}
+	w.lk.Unlock()
+	w.dispatch(snapshots)
+}
+
+// the node follow the retry snapshot of the job
+func (w *JobWorkflow) rebindNode(snapshotId string, retryId string) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	node, err := w.findNode(snapshotId)
+	if err != nil || node == nil || node.Status != WorkflowNodeDoingStatus {
+		return
+	}
+	node.SnapshotId = retryId
+	w.updateNode(node)
+}
+
+// advance the instance, mark the ready nodes doing and return the snapshots of them, must hold the lock
+func (w *JobWorkflow) advance(instance *WorkflowInstance) (snapshots []*JobSnapshot) {
+	if instance.Status != WorkflowInstanceDoingStatus {
+		return
+	}
+	conf, err := UnpackWorkflowConf([]byte(instance.Conf))
+	if err != nil {
+		log.Errorf("unpack the conf of the workflow instance: %s error: %v", instance.Id, err)
+		return
+	}
+	nodes, err := w.loadNodes(instance.Id)
+	if err != nil {
+		log.Errorf("load the nodes of the workflow instance: %s error: %v", instance.Id, err)
+		return
+	}
+	states := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		states[node.JobId] = node.Status
+	}
+	for {
+		ready, skipped := workflowNextNodes(conf, states)
+		if len(ready) == 0 && len(skipped) == 0 {
+			break
+		}
+		for _, jobId := range skipped {
+			states[jobId] = WorkflowNodeSkippedStatus
+			w.updateNode(&WorkflowInstanceNode{InstanceId: instance.Id, JobId: jobId, Status: WorkflowNodeSkippedStatus})
+		}
+		for _, jobId := range ready {
+			jobConf, err := w.node.manager.GetJob(jobId)
+			if err != nil {
+				log.Warnf("the workflow instance: %s load the job conf: %s error: %v", instance.Id, jobId, err)
+				states[jobId] = WorkflowNodeFailureStatus
+				w.updateNode(&WorkflowInstanceNode{InstanceId: instance.Id, JobId: jobId, Status: WorkflowNodeFailureStatus})
+				continue
+			}
+			snapshot := w.node.manager.newJobSnapshot(jobConf, time.Now())
+			states[jobId] = WorkflowNodeDoingStatus
+			w.updateNode(&WorkflowInstanceNode{InstanceId: instance.Id, JobId: jobId, SnapshotId: snapshot.Id, Status: WorkflowNodeDoingStatus})
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	status, finished := workflowInstanceStatus(states)
+	if !finished {
+		return
+	}
+	instance.Status = status
+	instance.FinishTime = ToDateString(time.Now())
+	err = w.node.UseTable(TableWorkflowInstance).Find(db.Cond{`id`: instance.Id}).Update(instance)
+	if err != nil {
+		log.Errorf("update the workflow instance: %s error: %v", instance.Id, err)
+		return
+	}
+	log.Infof("the workflow instance: %s finished, status: %d", instance.Id, status)
+	return
+}
+
+// dispatch the snapshots of the ready nodes, must not hold the lock
+func (w *JobWorkflow) dispatch(snapshots []*JobSnapshot) {
+	for _, snapshot := range snapshots {
+		if err := w.node.manager.ManualExecute(snapshot); err != nil {
+			log.Errorf("dispatch the snapshot: %s of the workflow error: %v", snapshot.Id, err)
+			w.finishNode(snapshot.Id, WorkflowNodeFailureStatus)
+		}
+	}
+}
+
+func (w *JobWorkflow) updateNode(node *WorkflowInstanceNode) {
+	node.UpdateTime = ToDateString(time.Now())
+	err := w.node.UseTable(TableWorkflowInstanceNode).
+		Find(db.Cond{`instance_id`: node.InstanceId, `job_id`: node.JobId}).
+		Update(node)
+	if err != nil {
+		log.Errorf("update the node: %s of the workflow instance: %s error: %v", node.JobId, node.InstanceId, err)
+	}
+}
+
+func (w *JobWorkflow) loadNodes(instanceId string) (nodes []*WorkflowInstanceNode, err error) {
+	err = w.node.UseTable(TableWorkflowInstanceNode).Find(db.Cond{`instance_id`: instanceId}).All(&nodes)
+	return
+}
+
+// find the node by the snapshot id, return nil if not exist
+func (w *JobWorkflow) findNode(snapshotId string) (node *WorkflowInstanceNode, err error) {
+	node = new(WorkflowInstanceNode)
+	err = w.node.UseTable(TableWorkflowInstanceNode).Find(db.Cond{`snapshot_id`: snapshotId}).One(node)
+	if err != nil {
+		node = nil
+		if err == db.ErrNoMoreRows {
+			err = nil
+		}
+	}
+	return
+}
+
+// find the instance, return nil if not exist
+func (w *JobWorkflow) findInstance(id string) (instance *WorkflowInstance, err error) {
+	instance = new(WorkflowInstance)
+	err = w.node.UseTable(TableWorkflowInstance).Find(db.Cond{`id`: id}).One(instance)
+	if err != nil {
+		instance = nil
+		if err == db.ErrNoMoreRows {
+			err = nil
+		}
+	}
+	return
+}
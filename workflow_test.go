@@ -0,0 +1,93 @@
+package forest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckWorkflowDAG(t *testing.T) {
+	conf := &WorkflowConf{
+		Nodes: []string{"a", "b", "c"},
+		Edges: []*WorkflowEdge{{From: "a", To: "b"}, {From: "b", To: "c"}},
+	}
+	if err := checkWorkflowDAG(conf); err != nil {
+		t.Fatal(err)
+	}
+	conf.Edges = append(conf.Edges, &WorkflowEdge{From: "c", To: "a"})
+	if err := checkWorkflowDAG(conf); err == nil {
+		t.Fatal("the cycle not detected")
+	}
+	conf.Edges = []*WorkflowEdge{{From: "a", To: "d"}}
+	if err := checkWorkflowDAG(conf); err == nil {
+		t.Fatal("the unknown node not detected")
+	}
+}
+
+func TestWorkflowNextNodes(t *testing.T) {
+	conf := &WorkflowConf{
+		Nodes: []string{"extract", "transform", "alert", "load"},
+		Edges: []*WorkflowEdge{
+			{From: "extract", To: "transform"},
+			{From: "extract", To: "alert", On: WorkflowEdgeFailure},
+			{From: "transform", To: "load", On: WorkflowEdgeAlways},
+		},
+	}
+	states := map[string]int{}
+	ready, skipped := workflowNextNodes(conf, states)
+	if !reflect.DeepEqual(ready, []string{"extract"}) || len(skipped) > 0 {
+		t.Fatalf("ready: %v, skipped: %v", ready, skipped)
+	}
+
+	states["extract"] = WorkflowNodeSuccessStatus
+	ready, skipped = workflowNextNodes(conf, states)
+	if !reflect.DeepEqual(ready, []string{"transform"}) || !reflect.DeepEqual(skipped, []string{"alert"}) {
+		t.Fatalf("ready: %v, skipped: %v", ready, skipped)
+	}
+
+	states["transform"] = WorkflowNodeFailureStatus
+	states["alert"] = WorkflowNodeSkippedStatus
+	ready, skipped = workflowNextNodes(conf, states)
+	if !reflect.DeepEqual(ready, []string{"load"}) || len(skipped) > 0 {
+		t.Fatalf("ready: %v, skipped: %v", ready, skipped)
+	}
+
+	states["load"] = WorkflowNodeSuccessStatus
+	if status, finished := workflowInstanceStatus(states); !finished || status != WorkflowInstanceFailureStatus {
+		t.Fatalf("status: %d, finished: %v", status, finished)
+	}
+}
+
+func TestWorkflowSkippedNode(t *testing.T) {
+	cases := map[int]int{
+		JobExecuteSnapshotSuccessStatus:  WorkflowNodeSuccessStatus,
+		JobExecuteSnapshotErrorStatus:    WorkflowNodeFailureStatus,
+		JobExecuteSnapshotTimeoutStatus:  WorkflowNodeFailureStatus,
+		JobExecuteSnapshotNoClientStatus: WorkflowNodeFailureStatus,
+		JobExecuteSnapshotSkippedStatus:  WorkflowNodeSkippedStatus,
+		JobExecuteSnapshotDroppedStatus:  WorkflowNodeSkippedStatus,
+	}
+	for status, expected := range cases {
+		if nodeStatus := workflowNodeStatus(status); nodeStatus != expected {
+			t.Errorf("the node status of the execution status: %d: %d, expected %d", status, nodeStatus, expected)
+		}
+	}
+
+	// the skipped node neither follow the failure edges nor fail the instance
+	conf := &WorkflowConf{
+		Nodes: []string{"extract", "load", "alert"},
+		Edges: []*WorkflowEdge{
+			{From: "extract", To: "load"},
+			{From: "extract", To: "alert", On: WorkflowEdgeFailure},
+		},
+	}
+	states := map[string]int{"extract": workflowNodeStatus(JobExecuteSnapshotSkippedStatus)}
+	ready, skipped := workflowNextNodes(conf, states)
+	if len(ready) > 0 || !reflect.DeepEqual(skipped, []string{"load", "alert"}) {
+		t.Fatalf("ready: %v, skipped: %v", ready, skipped)
+	}
+	states["load"] = WorkflowNodeSkippedStatus
+	states["alert"] = WorkflowNodeSkippedStatus
+	if status, finished := workflowInstanceStatus(states); !finished || status != WorkflowInstanceSuccessStatus {
+		t.Fatalf("status: %d, finished: %v", status, finished)
+	}
+}